@@ -140,6 +140,28 @@ func testReproducibility(t *testing.T, when spec.G, it spec.S) {
 
 		compare(t, imageName1, imageName2)
 	})
+
+	it("remote/local with squashed layers", func() {
+		squashAndSave := func(t *testing.T, img imgutil.Image) {
+			h.AssertNil(t, img.AddLayer(layer1))
+			h.AssertNil(t, img.AddLayer(layer2))
+			layerPath, err := imgutil.Squash(img, h.FileDiffID(t, layer1))
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.Save())
+		}
+
+		img1, err := remote.NewImage(imageName1, authn.DefaultKeychain, remote.FromBaseImage(runnableBaseImageName))
+		h.AssertNil(t, err)
+		squashAndSave(t, img1)
+
+		img2, err := local.NewImage(imageName2, dockerClient, local.FromBaseImage(runnableBaseImageName))
+		h.AssertNil(t, err)
+		squashAndSave(t, img2)
+		h.PushImage(t, dockerClient, imageName2)
+
+		compare(t, imageName1, imageName2)
+	})
 }
 
 func compare(t *testing.T, img1, img2 string) {
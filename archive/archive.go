@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+)
+
+var _ imgutil.Image = (*Image)(nil)
+
+var errAnnotationsNotSupported = errors.New("manifest annotations are not supported for images in a docker archive")
+
+// Image is an image read from and saved as a docker archive, i.e. a tar with a `manifest.json` as produced by
+// `docker save` or local.Image.SaveFile(). It overrides the methods of a layout image that read or write the
+// image on disk, so that `Name()` is the path of the archive.
+type Image struct {
+	layout.Image
+	repoTags []string // tags of the image in the archive it was read from
+	tmpPaths []string // copy of the archive the image reads its layers from and archives written by SaveFile, removed by Close
+}
+
+// Close removes the copy of the archive the image was read from and the archives written by SaveFile,
+// along with the temporary files of the layout image. The image must not be used once closed.
+func (i *Image) Close() error {
+	err := i.Image.Close()
+	for _, tmpPath := range i.tmpPaths {
+		if removeErr := os.RemoveAll(tmpPath); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	i.tmpPaths = nil
+	return err
+}
+
+// Found tells whether the archive exists at the path `Name()`.
+func (i *Image) Found() bool {
+	info, err := os.Stat(i.Name())
+	return err == nil && info.Mode().IsRegular()
+}
+
+func (i *Image) Valid() bool {
+	return i.Found()
+}
+
+// Annotations always returns an empty map, as docker archives do not store manifest annotations.
+func (i *Image) Annotations() (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// SetAnnotation always returns an error, as docker archives do not store manifest annotations.
+func (i *Image) SetAnnotation(key, val string) error {
+	return errAnnotationsNotSupported
+}
+
+// RemoveAnnotation always returns an error, as docker archives do not store manifest annotations.
+func (i *Image) RemoveAnnotation(key string) error {
+	return errAnnotationsNotSupported
+}
@@ -0,0 +1,256 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/archive"
+	"github.com/buildpacks/imgutil/layout"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestArchive(t *testing.T) {
+	spec.Run(t, "ArchiveImage", testImage, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testImage(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir      string
+		archivePath string
+		diffIDs     []string
+		err         error
+	)
+
+	it.Before(func() {
+		tmpDir, err = os.MkdirTemp("", "archive")
+		h.AssertNil(t, err)
+		archivePath = filepath.Join(tmpDir, "image.tar")
+
+		image, err := random.Image(1024, 2)
+		h.AssertNil(t, err)
+		cfg, err := image.ConfigFile()
+		h.AssertNil(t, err)
+		cfg = cfg.DeepCopy()
+		cfg.OS = "linux"
+		cfg.Architecture = "amd64"
+		cfg.Config.Labels = map[string]string{"some-label": "some-value"}
+		image, err = mutate.ConfigFile(image, cfg)
+		h.AssertNil(t, err)
+
+		layers, err := image.Layers()
+		h.AssertNil(t, err)
+		diffIDs = nil
+		for _, layer := range layers {
+			diffID, err := layer.DiffID()
+			h.AssertNil(t, err)
+			diffIDs = append(diffIDs, diffID.String())
+		}
+
+		tag, err := name.NewTag("some-image:some-tag")
+		h.AssertNil(t, err)
+		h.AssertNil(t, tarball.WriteToFile(archivePath, tag, image))
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#NewImageFromArchive", func() {
+		it("reads the config and layers of the image", func() {
+			img, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, img.Name(), archivePath)
+			h.AssertEq(t, img.Found(), true)
+
+			os, err := img.OS()
+			h.AssertNil(t, err)
+			h.AssertEq(t, os, "linux")
+			label, err := img.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffIDs[1])
+
+			rc, err := img.GetLayer(diffIDs[0])
+			h.AssertNil(t, err)
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(data) > 0, true)
+		})
+
+		when("the archive was written by local.Image.SaveFile()", func() {
+			it("reads the entries with absolute names", func() {
+				layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+				hash, err := v1.NewHash(diffID)
+				h.AssertNil(t, err)
+				config, err := json.Marshal(v1.ConfigFile{
+					OS:           "linux",
+					Architecture: "arm64",
+					RootFS:       v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{hash}},
+				})
+				h.AssertNil(t, err)
+				layer, err := os.ReadFile(layerPath)
+				h.AssertNil(t, err)
+				configName := fmt.Sprintf("/%x.json", sha256.Sum256(config))
+				manifest, err := json.Marshal([]map[string]interface{}{
+					{"Config": configName, "RepoTags": []string{"index.docker.io/library/some-image:latest"}, "Layers": []string{"/layer.tar"}},
+				})
+				h.AssertNil(t, err)
+				localArchivePath := filepath.Join(tmpDir, "local.tar")
+				writeTar(t, localArchivePath, map[string][]byte{configName: config, "/layer.tar": layer, "/manifest.json": manifest})
+
+				img, err := archive.NewImageFromArchive(localArchivePath)
+				h.AssertNil(t, err)
+				arch, err := img.Architecture()
+				h.AssertNil(t, err)
+				h.AssertEq(t, arch, "arm64")
+				topLayer, err := img.TopLayer()
+				h.AssertNil(t, err)
+				h.AssertEq(t, topLayer, diffID)
+			})
+		})
+
+		when("the archive does not exist", func() {
+			it("returns an error", func() {
+				_, err := archive.NewImageFromArchive(filepath.Join(tmpDir, "missing.tar"))
+				h.AssertError(t, err, "copying archive")
+			})
+		})
+	})
+
+	when("#Save", func() {
+		it("saves the modified image back to the archive with its tags", func() {
+			img, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("other-label", "other-value"))
+			layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.Save())
+
+			manifest, err := tarball.LoadManifest(func() (io.ReadCloser, error) { return os.Open(archivePath) })
+			h.AssertNil(t, err)
+			h.AssertEq(t, manifest[0].RepoTags, []string{"some-image:some-tag"})
+
+			saved, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+			label, err := saved.Label("other-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "other-value")
+			topLayer, err := saved.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffID)
+			rc, err := saved.GetLayer(diffIDs[0])
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+		})
+
+		it("saves the image with its creation time and a history entry for each layer", func() {
+			createdAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+			img, err := archive.NewImageFromArchive(archivePath, layout.WithCreatedAt(createdAt))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.Save())
+
+			saved, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+			savedCreatedAt, err := saved.CreatedAt()
+			h.AssertNil(t, err)
+			h.AssertEq(t, savedCreatedAt, createdAt)
+			history, err := saved.History()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(history), 2)
+			h.AssertEq(t, history[0], v1.History{Created: v1.Time{Time: createdAt}})
+		})
+
+		when("the image has a ref name", func() {
+			it("saves the image with the ref name as its tag to every path", func() {
+				img, err := archive.NewImageFromArchive(archivePath)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.AnnotateRefName("other-image:other-tag"))
+				otherPath := filepath.Join(tmpDir, "other.tar")
+				h.AssertNil(t, img.SaveAs(filepath.Join(tmpDir, "saved.tar"), otherPath))
+
+				for _, path := range []string{filepath.Join(tmpDir, "saved.tar"), otherPath} {
+					path := path
+					manifest, err := tarball.LoadManifest(func() (io.ReadCloser, error) { return os.Open(path) })
+					h.AssertNil(t, err)
+					h.AssertEq(t, manifest[0].RepoTags, []string{"other-image:other-tag"})
+				}
+			})
+		})
+	})
+
+	when("#SetAnnotation", func() {
+		it("returns an error as docker archives do not store manifest annotations", func() {
+			img, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+
+			h.AssertError(t, img.SetAnnotation("some-key", "some-value"), "manifest annotations are not supported")
+			annotations, err := img.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(annotations), 0)
+		})
+	})
+
+	when("#SaveFile", func() {
+		it("saves the image as a docker archive", func() {
+			img, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+
+			path, err := img.SaveFile()
+			h.AssertNil(t, err)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			saved, err := archive.NewImageFromArchive(path)
+			h.AssertNil(t, err)
+			topLayer, err := saved.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffIDs[1])
+		})
+	})
+
+	when("#Close", func() {
+		it("removes the archives written by SaveFile", func() {
+			img, err := archive.NewImageFromArchive(archivePath)
+			h.AssertNil(t, err)
+			path, err := img.SaveFile()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Close())
+			_, err = os.Stat(path)
+			h.AssertEq(t, os.IsNotExist(err), true)
+			_, err = os.Stat(archivePath)
+			h.AssertNil(t, err)
+		})
+	})
+}
+
+func writeTar(t *testing.T, path string, files map[string][]byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	h.AssertNil(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for name, data := range files {
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(data)
+		h.AssertNil(t, err)
+	}
+	h.AssertNil(t, tw.Close())
+}
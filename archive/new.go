@@ -0,0 +1,135 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+)
+
+// NewImageFromArchive returns an Image read from the docker archive at the provided path, which can be modified
+// and saved as a docker archive. The archive must contain a single image.
+// The archive is copied to a temporary directory, which is removed by Close as the image reads its layers from there,
+// so that the image can be saved back to the same path.
+// Options are those of a layout image, the image keeps Docker media types unless others are requested.
+func NewImageFromArchive(path string, ops ...layout.ImageOption) (*Image, error) {
+	tmpDir, err := os.MkdirTemp("", "imgutil.archive.")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp dir")
+	}
+	archivePath := filepath.Join(tmpDir, "image.tar")
+	if err := copyArchive(path, archivePath); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, errors.Wrapf(err, "copying archive %q", path)
+	}
+
+	opener := normalizedOpener(archivePath)
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading manifest of archive %q", path)
+	}
+	base, err := tarball.Image(opener, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading image from archive %q", path)
+	}
+
+	allOps := append([]layout.ImageOption{layout.FromBaseImage(base), layout.WithMediaTypes(imgutil.DockerTypes)}, ops...)
+	img, err := layout.NewImage(path, allOps...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		Image:    *img,
+		repoTags: manifest[0].RepoTags,
+		tmpPaths: []string{tmpDir},
+	}, nil
+}
+
+func copyArchive(src, dst string) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Clean(dst))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// normalizedOpener opens the archive at the provided path with the leading "/" removed from the names of its entries
+// and from the paths in its `manifest.json`, as written by local.Image.SaveFile(), so they can be read by ggcr.
+func normalizedOpener(path string) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer f.Close()
+			pw.CloseWithError(normalizeArchive(f, pw))
+		}()
+		return pr, nil
+	}
+}
+
+func normalizeArchive(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		hdr.Name = strings.TrimPrefix(hdr.Name, "/")
+		if hdr.Name != "manifest.json" {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil { // #nosec G110
+				return err
+			}
+			continue
+		}
+
+		var manifest tarball.Manifest
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return errors.Wrap(err, "decoding manifest.json")
+		}
+		for idx := range manifest {
+			manifest[idx].Config = strings.TrimPrefix(manifest[idx].Config, "/")
+			for j := range manifest[idx].Layers {
+				manifest[idx].Layers[j] = strings.TrimPrefix(manifest[idx].Layers[j], "/")
+			}
+		}
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+func (i *Image) Save(additionalNames ...string) error {
+	return i.SaveAs(i.Name(), additionalNames...)
+}
+
+// SaveAs ignores the image `Name()` method and saves the image as a docker archive at the path name and the additional
+// paths provided to this method. The image is tagged in the archive with its ref name, see AnnotateRefName,
+// or else with the tags of the archive it was read from.
+// The image is prepared like a layout image, see layout.Image.PrepareToSave.
+func (i *Image) SaveAs(name string, additionalNames ...string) error {
+	if err := i.PrepareToSave(); err != nil {
+		return err
+	}
+
+	tags, err := i.tags()
+	if err != nil {
+		return err
+	}
+
+	var diagnostics []imgutil.SaveDiagnostic
+	pathsToSave := append([]string{name}, additionalNames...)
+	for _, path := range pathsToSave {
+		if err := i.writeArchive(path, tags); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: path, Cause: err})
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
+
+// SaveFile saves the image as a docker archive in a temporary directory and provides the filesystem location.
+// The archive is removed by Close.
+func (i *Image) SaveFile() (string, error) {
+	tmpDir, err := os.MkdirTemp("", "imgutil.archive.export.")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temp dir")
+	}
+	path := filepath.Join(tmpDir, "image.tar")
+	if err := i.SaveAs(path); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	i.tmpPaths = append(i.tmpPaths, tmpDir)
+	return path, nil
+}
+
+// tags returns the references of the image in the saved archives
+func (i *Image) tags() ([]name.Reference, error) {
+	repoTags := i.repoTags
+	if refName, _ := i.GetAnnotateRefName(); refName != "" {
+		repoTags = []string{refName}
+	}
+	var refs []name.Reference
+	for _, repoTag := range repoTags {
+		tag, err := name.NewTag(repoTag, name.WeakValidation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing tag %q", repoTag)
+		}
+		refs = append(refs, tag)
+	}
+	if len(refs) == 0 {
+		// an untagged image is written with a digest reference, which is not recorded in the archive
+		digest, err := i.Digest()
+		if err != nil {
+			return nil, err
+		}
+		ref, err := name.NewDigest("image@" + digest.String())
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// writeArchive writes the image to a temporary file renamed to the provided path,
+// so that an existing archive is only replaced once the image is written.
+func (i *Image) writeArchive(path string, tags []name.Reference) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	refToImage := make(map[name.Reference]v1.Image, len(tags))
+	for _, tag := range tags {
+		refToImage[tag] = i
+	}
+	if err := tarball.MultiRefWriteToFile(f.Name(), refToImage); err != nil {
+		return errors.Wrapf(err, "writing archive %q", path)
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,52 @@
+package containerd
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+)
+
+var _ imgutil.Image = (*Image)(nil)
+
+// Image is an image saved to a containerd content store and image service, e.g. to export images on Kubernetes nodes
+// without a Docker daemon. It overrides the methods of a layout image that read or write the image, so that `Name()`
+// is the name of the image in the image service.
+type Image struct {
+	layout.Image
+	content ContentStore
+	images  ImageService
+	ctx     context.Context
+}
+
+// Found tells whether the image exists in the image service by `Name()`.
+func (i *Image) Found() bool {
+	_, err := i.images.Get(i.ctx, i.Name())
+	return err == nil
+}
+
+func (i *Image) Valid() bool {
+	return i.Found()
+}
+
+// Identifier returns the name of the image with the digest of its manifest, e.g. docker.io/library/busybox@sha256:...
+func (i *Image) Identifier() (imgutil.Identifier, error) {
+	ref, err := name.ParseReference(i.Name(), name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing name %q", i.Name())
+	}
+	digest, err := i.Digest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting digest for image %q", i.Name())
+	}
+	return ref.Context().Digest(digest.String()), nil
+}
+
+// Delete removes the image with the name `Name()` from the image service, its blobs are left to the garbage collection
+// of the content store.
+func (i *Image) Delete() error {
+	return i.images.Delete(i.ctx, i.Name())
+}
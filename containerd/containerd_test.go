@@ -0,0 +1,183 @@
+package containerd_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/containerd"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestContainerd(t *testing.T) {
+	spec.Run(t, "ContainerdImage", testImage, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testImage(t *testing.T, when spec.G, it spec.S) {
+	var (
+		store  *containerd.MemoryStore
+		tmpDir string
+		err    error
+	)
+
+	it.Before(func() {
+		store = containerd.NewMemoryStore()
+		tmpDir, err = os.MkdirTemp("", "containerd")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	saveImage := func(name string, ops ...containerd.ImageOption) (*containerd.Image, string) {
+		img, err := containerd.NewImage(name, store, store, ops...)
+		h.AssertNil(t, err)
+		layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+		h.AssertNil(t, img.AddLayer(layerPath))
+		h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+		h.AssertNil(t, img.Save())
+		return img, diffID
+	}
+
+	when("#Save", func() {
+		it("writes the image to the content store and image service", func() {
+			img, diffID := saveImage("some-image:latest")
+			h.AssertEq(t, img.Found(), true)
+
+			saved, err := containerd.NewImage("other-image:latest", store, store, containerd.FromBaseImage("some-image:latest"))
+			h.AssertNil(t, err)
+			label, err := saved.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			topLayer, err := saved.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffID)
+			rc, err := saved.GetLayer(diffID)
+			h.AssertNil(t, err)
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(data) > 0, true)
+		})
+
+		it("labels the manifest with the blobs it references", func() {
+			img, _ := saveImage("some-image:latest")
+
+			desc, err := store.Get(context.TODO(), "some-image:latest")
+			h.AssertNil(t, err)
+			manifest, err := img.Manifest()
+			h.AssertNil(t, err)
+			labels := store.Labels(desc.Digest)
+			h.AssertEq(t, labels["containerd.io/gc.ref.content.config"], manifest.Config.Digest.String())
+			h.AssertEq(t, labels["containerd.io/gc.ref.content.l.0"], manifest.Layers[0].Digest.String())
+		})
+
+		it("saves the manifest annotations and a history entry for each layer", func() {
+			img, err := containerd.NewImage("some-image:latest", store, store)
+			h.AssertNil(t, err)
+			layerPath, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, img.Save())
+
+			desc, err := store.Get(context.TODO(), "some-image:latest")
+			h.AssertNil(t, err)
+			rc, err := store.ReadBlob(context.TODO(), desc.Digest)
+			h.AssertNil(t, err)
+			defer rc.Close()
+			var manifest v1.Manifest
+			h.AssertNil(t, json.NewDecoder(rc).Decode(&manifest))
+			h.AssertEq(t, manifest.Annotations["some-key"], "some-value")
+
+			saved, err := containerd.NewImage("other-image:latest", store, store, containerd.FromBaseImage("some-image:latest"))
+			h.AssertNil(t, err)
+			history, err := saved.History()
+			h.AssertNil(t, err)
+			h.AssertEq(t, history, []v1.History{{Created: v1.Time{Time: imgutil.NormalizedDateTime}}})
+		})
+
+		when("additional names are provided", func() {
+			it("saves the image with every name", func() {
+				img, err := containerd.NewImage("some-image:latest", store, store)
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.Save("some-image:other-tag"))
+
+				desc, err := store.Get(context.TODO(), "some-image:latest")
+				h.AssertNil(t, err)
+				otherDesc, err := store.Get(context.TODO(), "some-image:other-tag")
+				h.AssertNil(t, err)
+				h.AssertEq(t, otherDesc, desc)
+
+				identifier, err := img.Identifier()
+				h.AssertNil(t, err)
+				h.AssertEq(t, identifier.String(), "index.docker.io/library/some-image@"+desc.Digest.String())
+			})
+		})
+	})
+
+	when("#FromBaseImage", func() {
+		when("the base image does not exist", func() {
+			it("returns an empty image", func() {
+				img, err := containerd.NewImage("some-image:latest", store, store, containerd.FromBaseImage("missing-image:latest"))
+				h.AssertNil(t, err)
+				h.AssertEq(t, img.Found(), false)
+				_, err = img.TopLayer()
+				h.AssertError(t, err, "has no layers")
+			})
+		})
+
+		when("the base image is an index", func() {
+			it("chooses the image matching the platform", func() {
+				_, amd64DiffID := saveImage("amd64-image:latest", containerd.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "amd64"}))
+				_, arm64DiffID := saveImage("arm64-image:latest", containerd.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "arm64"}))
+				amd64Desc, err := store.Get(context.TODO(), "amd64-image:latest")
+				h.AssertNil(t, err)
+				amd64Desc.Platform = &v1.Platform{OS: "linux", Architecture: "amd64"}
+				arm64Desc, err := store.Get(context.TODO(), "arm64-image:latest")
+				h.AssertNil(t, err)
+				arm64Desc.Platform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+
+				rawIndex, err := json.Marshal(v1.IndexManifest{
+					SchemaVersion: 2,
+					MediaType:     types.OCIImageIndex,
+					Manifests:     []v1.Descriptor{amd64Desc, arm64Desc},
+				})
+				h.AssertNil(t, err)
+				digest, size, err := v1.SHA256(bytes.NewReader(rawIndex))
+				h.AssertNil(t, err)
+				indexDesc := v1.Descriptor{MediaType: types.OCIImageIndex, Digest: digest, Size: size}
+				h.AssertNil(t, store.WriteBlob(context.TODO(), indexDesc, bytes.NewReader(rawIndex), nil))
+				h.AssertNil(t, store.Set(context.TODO(), "multi-arch:latest", indexDesc))
+
+				img, err := containerd.NewImage("some-image:latest", store, store,
+					containerd.FromBaseImage("multi-arch:latest"),
+					containerd.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "arm64"}),
+				)
+				h.AssertNil(t, err)
+				topLayer, err := img.TopLayer()
+				h.AssertNil(t, err)
+				h.AssertEq(t, topLayer, arm64DiffID)
+				h.AssertNotEq(t, topLayer, amd64DiffID)
+			})
+		})
+	})
+
+	when("#Delete", func() {
+		it("removes the image from the image service", func() {
+			img, _ := saveImage("some-image:latest")
+
+			h.AssertNil(t, img.Delete())
+			h.AssertEq(t, img.Found(), false)
+		})
+	})
+}
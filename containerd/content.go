@@ -0,0 +1,169 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// readBlob returns the contents of the blob with the provided digest
+func readBlob(ctx context.Context, store ContentStore, digest v1.Hash) ([]byte, error) {
+	rc, err := store.ReadBlob(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// contentImage is a v1.Image whose manifest, config and layers are read from a content store
+type contentImage struct {
+	ctx         context.Context
+	store       ContentStore
+	rawManifest []byte
+	manifest    *v1.Manifest
+	mediaType   types.MediaType
+}
+
+func newContentImage(ctx context.Context, store ContentStore, desc v1.Descriptor) (v1.Image, error) {
+	rawManifest, err := readBlob(ctx, store, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(rawManifest))
+	if err != nil {
+		return nil, err
+	}
+	return partial.CompressedToImage(&contentImage{
+		ctx:         ctx,
+		store:       store,
+		rawManifest: rawManifest,
+		manifest:    manifest,
+		mediaType:   desc.MediaType,
+	})
+}
+
+func (i *contentImage) MediaType() (types.MediaType, error) {
+	if i.manifest.MediaType != "" {
+		return i.manifest.MediaType, nil
+	}
+	return i.mediaType, nil
+}
+
+func (i *contentImage) RawManifest() ([]byte, error) {
+	return i.rawManifest, nil
+}
+
+func (i *contentImage) RawConfigFile() ([]byte, error) {
+	return readBlob(i.ctx, i.store, i.manifest.Config.Digest)
+}
+
+func (i *contentImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	if digest == i.manifest.Config.Digest {
+		return &contentLayer{ctx: i.ctx, store: i.store, desc: i.manifest.Config}, nil
+	}
+	for _, desc := range i.manifest.Layers {
+		if desc.Digest == digest {
+			return &contentLayer{ctx: i.ctx, store: i.store, desc: desc}, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// contentLayer is a compressed layer read from a content store
+type contentLayer struct {
+	ctx   context.Context
+	store ContentStore
+	desc  v1.Descriptor
+}
+
+func (l *contentLayer) Digest() (v1.Hash, error) {
+	return l.desc.Digest, nil
+}
+
+func (l *contentLayer) Compressed() (io.ReadCloser, error) {
+	return l.store.ReadBlob(l.ctx, l.desc.Digest)
+}
+
+func (l *contentLayer) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+func (l *contentLayer) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
+}
+
+// contentIndex is a v1.ImageIndex whose manifests are read from a content store
+type contentIndex struct {
+	ctx      context.Context
+	store    ContentStore
+	rawIndex []byte
+	desc     v1.Descriptor
+}
+
+func newContentIndex(ctx context.Context, store ContentStore, desc v1.Descriptor) (v1.ImageIndex, error) {
+	rawIndex, err := readBlob(ctx, store, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return &contentIndex{ctx: ctx, store: store, rawIndex: rawIndex, desc: desc}, nil
+}
+
+func (i *contentIndex) MediaType() (types.MediaType, error) {
+	return i.desc.MediaType, nil
+}
+
+func (i *contentIndex) Digest() (v1.Hash, error) {
+	return i.desc.Digest, nil
+}
+
+func (i *contentIndex) Size() (int64, error) {
+	return i.desc.Size, nil
+}
+
+func (i *contentIndex) IndexManifest() (*v1.IndexManifest, error) {
+	var index v1.IndexManifest
+	if err := json.Unmarshal(i.rawIndex, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+func (i *contentIndex) RawManifest() ([]byte, error) {
+	return i.rawIndex, nil
+}
+
+func (i *contentIndex) Image(digest v1.Hash) (v1.Image, error) {
+	desc, err := i.childDescriptor(digest)
+	if err != nil {
+		return nil, err
+	}
+	return newContentImage(i.ctx, i.store, desc)
+}
+
+func (i *contentIndex) ImageIndex(digest v1.Hash) (v1.ImageIndex, error) {
+	desc, err := i.childDescriptor(digest)
+	if err != nil {
+		return nil, err
+	}
+	return newContentIndex(i.ctx, i.store, desc)
+}
+
+func (i *contentIndex) childDescriptor(digest v1.Hash) (v1.Descriptor, error) {
+	index, err := i.IndexManifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest == digest {
+			return desc, nil
+		}
+	}
+	return v1.Descriptor{}, ErrNotFound
+}
@@ -0,0 +1,84 @@
+package containerd
+
+import (
+	"context"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+)
+
+// NewImage returns a new Image with the provided name that can be modified and saved to the provided content store
+// and image service, such as a MemoryStore or adapters over a containerd client, see ContentStore.
+func NewImage(name string, content ContentStore, images ImageService, ops ...ImageOption) (*Image, error) {
+	imageOpts := &options{}
+	for _, op := range ops {
+		if err := op(imageOpts); err != nil {
+			return nil, err
+		}
+	}
+	ctx := imageOpts.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	layoutOps := imageOpts.layoutOptions
+	if imageOpts.baseImageName != "" {
+		baseImage, err := newV1Image(ctx, content, images, imageOpts.baseImageName, imageOpts.platform)
+		if err != nil {
+			return nil, err
+		}
+		if baseImage != nil {
+			layoutOps = append([]layout.ImageOption{layout.FromBaseImage(baseImage)}, layoutOps...)
+		}
+	}
+
+	img, err := layout.NewImage(name, layoutOps...)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{
+		Image:   *img,
+		content: content,
+		images:  images,
+		ctx:     ctx,
+	}, nil
+}
+
+// newV1Image returns the image with the provided name in the image service, choosing the image matching the provided
+// platform from an index, or nil if the image is not found.
+func newV1Image(ctx context.Context, content ContentStore, images ImageService, name string, platform imgutil.Platform) (v1.Image, error) {
+	desc, err := images.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting image %q", name)
+	}
+
+	if !desc.MediaType.IsIndex() {
+		image, err := newContentImage(ctx, content, desc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading image %q", name)
+		}
+		return image, nil
+	}
+
+	index, err := newContentIndex(ctx, content, desc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading index %q", name)
+	}
+	if (platform == imgutil.Platform{}) {
+		platform = imgutil.Platform{OS: "linux", Architecture: "amd64"}
+	}
+	image, err := imgutil.ImageFromIndex(index, imgutil.V1Platform(platform))
+	if errors.As(err, &imgutil.PlatformNotFoundError{}) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading index %q", name)
+	}
+	return image, nil
+}
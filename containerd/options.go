@@ -0,0 +1,56 @@
+package containerd
+
+import (
+	"context"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+)
+
+type ImageOption func(*options) error
+
+type options struct {
+	platform      imgutil.Platform
+	baseImageName string
+	ctx           context.Context
+	layoutOptions []layout.ImageOption
+}
+
+// FromBaseImage loads an existing image of the image service as the config and layers for the new image.
+// FromBaseImage will use the platform provided with WithDefaultPlatform to choose an image from an index.
+// Ignored if image is not found.
+func FromBaseImage(name string) ImageOption {
+	return func(opts *options) error {
+		opts.baseImageName = name
+		return nil
+	}
+}
+
+// WithContext lets a caller provide the context used by the content store and image service operations of the image.
+// Defaults to context.Background().
+func WithContext(ctx context.Context) ImageOption {
+	return func(opts *options) error {
+		opts.ctx = ctx
+		return nil
+	}
+}
+
+// WithDefaultPlatform provides Architecture/OS/OSVersion defaults for the new image.
+// Defaults for a new image are ignored when FromBaseImage returns an image.
+func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
+	return func(opts *options) error {
+		opts.platform = platform
+		opts.layoutOptions = append(opts.layoutOptions, layout.WithDefaultPlatform(platform))
+		return nil
+	}
+}
+
+// WithMediaTypes lets a caller set the desired media types for the image manifest and config files,
+// including the layers referenced in the manifest, to be either OCI media types or Docker media types.
+// Defaults to OCI media types.
+func WithMediaTypes(requested imgutil.MediaTypes) ImageOption {
+	return func(opts *options) error {
+		opts.layoutOptions = append(opts.layoutOptions, layout.WithMediaTypes(requested))
+		return nil
+	}
+}
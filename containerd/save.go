@@ -0,0 +1,121 @@
+package containerd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+func (i *Image) Save(additionalNames ...string) error {
+	return i.SaveAs(i.Name(), additionalNames...)
+}
+
+// SaveAs ignores the image `Name()` method and saves the image according to name & additional names provided to this method.
+// The layers and config missing from the content store are written first, then the manifest, labeled so that
+// containerd does not garbage collect the blobs it references, and the image service is updated for every name.
+// The image is prepared like a layout image, see layout.Image.PrepareToSave.
+func (i *Image) SaveAs(name string, additionalNames ...string) error {
+	if err := i.PrepareToSave(); err != nil {
+		return err
+	}
+
+	manifestDesc, err := i.writeBlobs()
+	if err != nil {
+		return errors.Wrapf(err, "writing image %q to content store", name)
+	}
+
+	var diagnostics []imgutil.SaveDiagnostic
+	for _, n := range append([]string{name}, additionalNames...) {
+		if err := i.images.Set(i.ctx, n, manifestDesc); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
+
+// writeBlobs writes the layers, config and manifest of the image missing from the content store,
+// and returns the descriptor of the manifest.
+func (i *Image) writeBlobs() (v1.Descriptor, error) {
+	manifest, err := i.Manifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	layers, err := i.Layers()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	for idx, layer := range layers {
+		desc := manifest.Layers[idx]
+		if i.hasBlob(desc) {
+			continue
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return v1.Descriptor{}, errors.Wrapf(err, "reading layer %q", desc.Digest)
+		}
+		err = i.content.WriteBlob(i.ctx, desc, rc, nil)
+		rc.Close()
+		if err != nil {
+			return v1.Descriptor{}, errors.Wrapf(err, "writing layer %q", desc.Digest)
+		}
+	}
+
+	rawConfig, err := i.RawConfigFile()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	if err := i.writeBlob(manifest.Config, rawConfig, nil); err != nil {
+		return v1.Descriptor{}, errors.Wrap(err, "writing config")
+	}
+
+	rawManifest, err := i.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	mediaType, err := i.MediaType()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(rawManifest))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	manifestDesc := v1.Descriptor{MediaType: mediaType, Digest: digest, Size: size}
+	if err := i.writeBlob(manifestDesc, rawManifest, gcLabels(manifest)); err != nil {
+		return v1.Descriptor{}, errors.Wrap(err, "writing manifest")
+	}
+	return manifestDesc, nil
+}
+
+func (i *Image) writeBlob(desc v1.Descriptor, blob []byte, labels map[string]string) error {
+	if i.hasBlob(desc) && labels == nil {
+		return nil
+	}
+	return i.content.WriteBlob(i.ctx, desc, io.NopCloser(bytes.NewReader(blob)), labels)
+}
+
+func (i *Image) hasBlob(desc v1.Descriptor) bool {
+	size, err := i.content.Info(i.ctx, desc.Digest)
+	return err == nil && size == desc.Size
+}
+
+// gcLabels returns the containerd.io/gc.ref.content labels referencing the config and layers of the manifest
+func gcLabels(manifest *v1.Manifest) map[string]string {
+	labels := map[string]string{
+		"containerd.io/gc.ref.content.config": manifest.Config.Digest.String(),
+	}
+	for idx, layer := range manifest.Layers {
+		labels[fmt.Sprintf("containerd.io/gc.ref.content.l.%d", idx)] = layer.Digest.String()
+	}
+	return labels
+}
@@ -0,0 +1,132 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned, possibly wrapped, by stores when a blob or an image does not exist.
+// Stores backed by a containerd client must translate its errdefs.ErrNotFound to it.
+var ErrNotFound = errors.New("not found")
+
+// ContentStore is the subset of the containerd content store used by Image: blobs addressed by their digest.
+// The content.Store of a containerd client does not implement it: this package does not depend on the containerd client,
+// so callers provide an adapter over their client.
+type ContentStore interface {
+	// Info returns the size of the blob with the provided digest, or ErrNotFound.
+	Info(ctx context.Context, digest v1.Hash) (int64, error)
+	// ReadBlob returns a reader of the blob with the provided digest, or ErrNotFound.
+	ReadBlob(ctx context.Context, digest v1.Hash) (io.ReadCloser, error)
+	// WriteBlob writes the blob described by the provided descriptor with the provided labels,
+	// e.g. the containerd.io/gc.ref.content labels protecting the blobs referenced by a manifest from garbage collection.
+	// The contents must match the digest and size of the descriptor.
+	WriteBlob(ctx context.Context, desc v1.Descriptor, r io.Reader, labels map[string]string) error
+}
+
+// ImageService is the subset of the containerd image service used by Image: named references to a manifest or an index.
+// As for ContentStore, the images.Store of a containerd client is used through an adapter provided by the caller.
+type ImageService interface {
+	// Get returns the descriptor of the manifest or index of the image with the provided name, or ErrNotFound.
+	Get(ctx context.Context, name string) (v1.Descriptor, error)
+	// Set creates or updates the image with the provided name.
+	Set(ctx context.Context, name string, target v1.Descriptor) error
+	// Delete removes the image with the provided name, but not its blobs.
+	Delete(ctx context.Context, name string) error
+}
+
+// MemoryStore is an in-memory ContentStore and ImageService, standing in for containerd e.g. in tests.
+type MemoryStore struct {
+	blobs  map[v1.Hash][]byte
+	labels map[v1.Hash]map[string]string
+	images map[string]v1.Descriptor
+	mu     sync.Mutex
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blobs:  map[v1.Hash][]byte{},
+		labels: map[v1.Hash]map[string]string{},
+		images: map[string]v1.Descriptor{},
+	}
+}
+
+func (s *MemoryStore) Info(_ context.Context, digest v1.Hash) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob, ok := s.blobs[digest]
+	if !ok {
+		return 0, errors.Wrapf(ErrNotFound, "blob %q", digest)
+	}
+	return int64(len(blob)), nil
+}
+
+func (s *MemoryStore) ReadBlob(_ context.Context, digest v1.Hash) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob, ok := s.blobs[digest]
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "blob %q", digest)
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func (s *MemoryStore) WriteBlob(_ context.Context, desc v1.Descriptor, r io.Reader, labels map[string]string) error {
+	blob, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	if digest != desc.Digest || size != desc.Size {
+		return fmt.Errorf("blob does not match descriptor: expected %s with size %d, got %s with size %d", desc.Digest, desc.Size, digest, size)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[digest] = blob
+	s.labels[digest] = labels
+	return nil
+}
+
+// Labels returns the labels of the blob with the provided digest.
+func (s *MemoryStore) Labels(digest v1.Hash) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.labels[digest]
+}
+
+func (s *MemoryStore) Get(_ context.Context, name string) (v1.Descriptor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	desc, ok := s.images[name]
+	if !ok {
+		return v1.Descriptor{}, errors.Wrapf(ErrNotFound, "image %q", name)
+	}
+	return desc, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, name string, target v1.Descriptor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[name] = target
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.images[name]; !ok {
+		return errors.Wrapf(ErrNotFound, "image %q", name)
+	}
+	delete(s.images, name)
+	return nil
+}
@@ -0,0 +1,40 @@
+package imgutil
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+// underlyingImageSetter is implemented by images whose config and layers can be replaced with those of a v1.Image,
+// such as remote, layout and local images.
+type underlyingImageSetter interface {
+	SetUnderlyingImage(image v1.Image) error
+}
+
+// Copy replaces the config and layers of dst with those of src and saves dst, e.g. to push an image from the docker daemon
+// to a registry or to load an image from an OCI layout into the daemon. src and dst can be images from any backend.
+// Layers the destination already has are not written again: blobs that exist in the registry or in the layout are skipped,
+// and so are the bottom layers of a local dst created from an image in the daemon.
+// The manifest annotations of dst are those reported by src.Annotations(), including those set and not saved yet.
+// dst is saved like any other image of its backend, e.g. its creation time and history depend on the options it was created with.
+func Copy(src, dst Image) error {
+	srcImage, err := V1Image(src)
+	if err != nil {
+		return errors.Wrap(err, "reading source image")
+	}
+	annotations, err := src.Annotations()
+	if err != nil {
+		return errors.Wrap(err, "reading source annotations")
+	}
+	srcImage = WithManifestAnnotations(srcImage, annotations)
+	setter, ok := dst.(underlyingImageSetter)
+	if !ok {
+		return fmt.Errorf("image %q does not accept the config and layers of another image", dst.Name())
+	}
+	if err := setter.SetUnderlyingImage(srcImage); err != nil {
+		return errors.Wrapf(err, "copying image %q to %q", src.Name(), dst.Name())
+	}
+	return dst.Save()
+}
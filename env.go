@@ -0,0 +1,113 @@
+package imgutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvVar is an environment variable of an image, see Image.Envs.
+type EnvVar struct {
+	Key   string
+	Value string
+}
+
+// The following functions manage the environment variables of an image config, given as "KEY=VALUE" entries,
+// for an image with the provided OS. Keys are case-insensitive on windows, as they are for processes on windows.
+
+// EnvValue returns the value of the variable with the provided key, and whether the variable is set.
+func EnvValue(env []string, os, key string) (string, bool) {
+	for _, kv := range env {
+		k, v := splitEnv(kv)
+		if envKeyEqual(os, k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// EnvVars returns the variables of the provided environment in order.
+func EnvVars(env []string) []EnvVar {
+	vars := make([]EnvVar, len(env))
+	for idx, kv := range env {
+		k, v := splitEnv(kv)
+		vars[idx] = EnvVar{Key: k, Value: v}
+	}
+	return vars
+}
+
+// SetEnv returns a copy of the provided environment with the variable with the provided key set to val,
+// replacing the variable in place if it is set, or appending it otherwise.
+func SetEnv(env []string, os, key, val string) []string {
+	updated := make([]string, 0, len(env)+1)
+	found := false
+	for _, kv := range env {
+		k, _ := splitEnv(kv)
+		if envKeyEqual(os, k, key) {
+			if !found {
+				updated = append(updated, key+"="+val)
+				found = true
+			}
+			continue
+		}
+		updated = append(updated, kv)
+	}
+	if !found {
+		updated = append(updated, key+"="+val)
+	}
+	return updated
+}
+
+// RemoveEnv returns a copy of the provided environment without the variable with the provided key.
+func RemoveEnv(env []string, os, key string) []string {
+	updated := make([]string, 0, len(env))
+	for _, kv := range env {
+		k, _ := splitEnv(kv)
+		if !envKeyEqual(os, k, key) {
+			updated = append(updated, kv)
+		}
+	}
+	return updated
+}
+
+// AppendEnv returns a copy of the provided environment with val appended to the list held by the variable with the provided key,
+// such as PATH, using the list separator of the OS, or with the variable set to val if it is unset or empty.
+func AppendEnv(env []string, os, key, val string) []string {
+	current, _ := EnvValue(env, os, key)
+	if current == "" {
+		return SetEnv(env, os, key, val)
+	}
+	return SetEnv(env, os, key, fmt.Sprintf("%s%s%s", current, envListSeparator(os), val))
+}
+
+// PrependEnv returns a copy of the provided environment with val prepended to the list held by the variable with the provided key,
+// such as PATH, using the list separator of the OS, or with the variable set to val if it is unset or empty.
+func PrependEnv(env []string, os, key, val string) []string {
+	current, _ := EnvValue(env, os, key)
+	if current == "" {
+		return SetEnv(env, os, key, val)
+	}
+	return SetEnv(env, os, key, fmt.Sprintf("%s%s%s", val, envListSeparator(os), current))
+}
+
+// splitEnv splits a "KEY=VALUE" entry on its first "=", so that values may contain "=".
+func splitEnv(kv string) (string, string) {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func envKeyEqual(os, a, b string) bool {
+	if os == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+func envListSeparator(os string) string {
+	if os == "windows" {
+		return ";"
+	}
+	return ":"
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	registryName "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
@@ -21,7 +22,6 @@ import (
 func NewImage(name, topLayerSha string, identifier imgutil.Identifier) *Image {
 	return &Image{
 		labels:           nil,
-		env:              map[string]string{},
 		topLayerSha:      topLayerSha,
 		identifier:       identifier,
 		name:             name,
@@ -44,7 +44,7 @@ type Image struct {
 	prevLayersMap    map[string]string
 	reusedLayers     []string
 	labels           map[string]string
-	env              map[string]string
+	env              []string
 	topLayerSha      string
 	os               string
 	osVersion        string
@@ -61,7 +61,15 @@ type Image struct {
 	savedNames       map[string]bool
 	manifestSize     int64
 	refName          string
+	annotations      map[string]string
 	savedAnnotations map[string]string
+	history          []v1.History
+	exposedPorts     map[string]struct{}
+	healthcheck      *v1.HealthConfig
+	shell            []string
+	stopSignal       string
+	user             string
+	volumes          map[string]struct{}
 }
 
 func (i *Image) CreatedAt() (time.Time, error) {
@@ -126,8 +134,107 @@ func (i *Image) RemoveLabel(key string) error {
 	return nil
 }
 
+func (i *Image) Annotations() (map[string]string, error) {
+	annotations := make(map[string]string, len(i.annotations))
+	for k, v := range i.annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
+func (i *Image) SetAnnotation(k string, v string) error {
+	if i.annotations == nil {
+		i.annotations = map[string]string{}
+	}
+	i.annotations[k] = v
+	return nil
+}
+
+func (i *Image) RemoveAnnotation(key string) error {
+	delete(i.annotations, key)
+	return nil
+}
+
 func (i *Image) SetEnv(k string, v string) error {
-	i.env[k] = v
+	i.env = imgutil.SetEnv(i.env, i.os, k, v)
+	return nil
+}
+
+func (i *Image) AppendEnv(k string, v string) error {
+	i.env = imgutil.AppendEnv(i.env, i.os, k, v)
+	return nil
+}
+
+func (i *Image) PrependEnv(k string, v string) error {
+	i.env = imgutil.PrependEnv(i.env, i.os, k, v)
+	return nil
+}
+
+func (i *Image) RemoveEnv(k string) error {
+	i.env = imgutil.RemoveEnv(i.env, i.os, k)
+	return nil
+}
+
+func (i *Image) History() ([]v1.History, error) {
+	return i.history, nil
+}
+
+func (i *Image) SetHistory(history []v1.History) error {
+	i.history = history
+	return nil
+}
+
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	return i.exposedPorts, nil
+}
+
+func (i *Image) SetExposedPorts(ports map[string]struct{}) error {
+	i.exposedPorts = ports
+	return nil
+}
+
+func (i *Image) Healthcheck() (*v1.HealthConfig, error) {
+	return i.healthcheck, nil
+}
+
+func (i *Image) SetHealthcheck(healthcheck *v1.HealthConfig) error {
+	i.healthcheck = healthcheck
+	return nil
+}
+
+func (i *Image) Shell() ([]string, error) {
+	return i.shell, nil
+}
+
+func (i *Image) SetShell(shell ...string) error {
+	i.shell = shell
+	return nil
+}
+
+func (i *Image) StopSignal() (string, error) {
+	return i.stopSignal, nil
+}
+
+func (i *Image) SetStopSignal(signal string) error {
+	i.stopSignal = signal
+	return nil
+}
+
+func (i *Image) User() (string, error) {
+	return i.user, nil
+}
+
+func (i *Image) SetUser(user string) error {
+	i.user = user
+	return nil
+}
+
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	return i.volumes, nil
+}
+
+func (i *Image) SetVolumes(volumes map[string]struct{}) error {
+	i.volumes = volumes
 	return nil
 }
 
@@ -172,7 +279,12 @@ func (i *Image) SetCreatedAt(t time.Time) error {
 }
 
 func (i *Image) Env(k string) (string, error) {
-	return i.env[k], nil
+	v, _ := imgutil.EnvValue(i.env, i.os, k)
+	return v, nil
+}
+
+func (i *Image) Envs() ([]imgutil.EnvVar, error) {
+	return imgutil.EnvVars(i.env), nil
 }
 
 func (i *Image) TopLayer() (string, error) {
@@ -185,14 +297,17 @@ func (i *Image) AddLayer(path string) error {
 		return err
 	}
 
-	i.layersMap["sha256:"+sha] = path
-	i.layers = append(i.layers, path)
-	return nil
+	return i.AddLayerWithDiffID(path, "sha256:"+sha)
 }
 
 func (i *Image) AddLayerWithDiffID(path string, diffID string) error {
+	return i.AddLayerWithDiffIDAndHistory(path, diffID, v1.History{})
+}
+
+func (i *Image) AddLayerWithDiffIDAndHistory(path string, diffID string, history v1.History) error {
 	i.layersMap[diffID] = path
 	i.layers = append(i.layers, path)
+	i.history = append(i.history, history)
 	return nil
 }
 
@@ -220,13 +335,74 @@ func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
 	return os.Open(filepath.Clean(path))
 }
 
+func (i *Image) LayerDescriptors() ([]imgutil.LayerDescriptor, error) {
+	var descriptors []imgutil.LayerDescriptor
+	for _, path := range i.layers {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		for diffID, layerPath := range i.layersMap {
+			if layerPath == path {
+				descriptors = append(descriptors, imgutil.LayerDescriptor{DiffID: diffID, Digest: diffID, Size: fi.Size()})
+				break
+			}
+		}
+	}
+	return descriptors, nil
+}
+
+func (i *Image) RemoveLayer(diffID string) error {
+	path, ok := i.layersMap[diffID]
+	if !ok {
+		return fmt.Errorf("image does not have layer with sha '%s'", diffID)
+	}
+	for idx, layerPath := range i.layers {
+		if layerPath == path {
+			i.layers = append(i.layers[:idx], i.layers[idx+1:]...)
+			break
+		}
+	}
+	for _, layerPath := range i.layers {
+		if layerPath == path {
+			return nil // the layer was added more than once
+		}
+	}
+	delete(i.layersMap, diffID)
+	return nil
+}
+
+func (i *Image) ReplaceLayer(diffID, path string) error {
+	oldPath, ok := i.layersMap[diffID]
+	if !ok {
+		return fmt.Errorf("image does not have layer with sha '%s'", diffID)
+	}
+	sha, err := shaForFile(path)
+	if err != nil {
+		return err
+	}
+	delete(i.layersMap, diffID)
+	i.layersMap["sha256:"+sha] = path
+	for idx, layerPath := range i.layers {
+		if layerPath == oldPath {
+			i.layers[idx] = path
+		}
+	}
+	return nil
+}
+
 func (i *Image) ReuseLayer(sha string) error {
+	return i.ReuseLayerWithHistory(sha, v1.History{})
+}
+
+func (i *Image) ReuseLayerWithHistory(sha string, history v1.History) error {
 	prevLayer, ok := i.prevLayersMap[sha]
 	if !ok {
 		return fmt.Errorf("image does not have previous layer with sha '%s'", sha)
 	}
 	i.reusedLayers = append(i.reusedLayers, sha)
 	i.layersMap[sha] = prevLayer
+	i.history = append(i.history, history)
 	return nil
 }
 
@@ -253,6 +429,9 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 	}
 
 	allNames := append([]string{name}, additionalNames...)
+	for k, v := range i.annotations {
+		i.savedAnnotations[k] = v
+	}
 	if i.refName != "" {
 		i.savedAnnotations["org.opencontainers.image.ref.name"] = i.refName
 	}
@@ -298,6 +477,11 @@ func (i *Image) copyLayer(path, newPath string) error {
 	return nil
 }
 
+// Close removes the layers of the image, like Cleanup.
+func (i *Image) Close() error {
+	return i.Cleanup()
+}
+
 func (i *Image) Delete() error {
 	i.deleted = true
 	return nil
@@ -137,6 +137,21 @@ Layers
 		})
 	})
 
+	when("#SetAnnotation", func() {
+		var repoName = newRepoName()
+
+		it("saves the annotations", func() {
+			image := fakes.NewImage(repoName, "", nil)
+			h.AssertNil(t, image.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, image.SetAnnotation("other-key", "other-value"))
+			h.AssertNil(t, image.RemoveAnnotation("other-key"))
+
+			_ = image.Save()
+
+			h.AssertEq(t, image.SavedAnnotations(), map[string]string{"some-key": "some-value"})
+		})
+	})
+
 	when("#AnnotateRefName", func() {
 		var repoName = newRepoName()
 
@@ -1,6 +1,7 @@
 package imgutil
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
@@ -9,16 +10,26 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
 type Image interface {
 	// getters
 
+	// Annotations returns the annotations written to the image manifest.
+	Annotations() (map[string]string, error)
 	Architecture() (string, error)
+	Cmd() ([]string, error)
 	CreatedAt() (time.Time, error)
 	Entrypoint() ([]string, error)
+	// Env returns the value of the environment variable with the provided key, or an empty string if it is unset.
+	// Keys are case-insensitive for windows images.
 	Env(key string) (string, error)
+	// Envs returns the environment variables of the image, in the order of the image config.
+	Envs() ([]EnvVar, error)
+	// ExposedPorts returns the ports exposed by containers of the image, as "port/protocol" keys, e.g. "8080/tcp".
+	ExposedPorts() (map[string]struct{}, error)
 	// Found tells whether the image exists in the repository by `Name()`.
 	Found() bool
 	// Valid returns true if the image is well formed (e.g. all manifest layers exist on the registry).
@@ -26,57 +37,107 @@ type Image interface {
 	GetAnnotateRefName() (string, error)
 	// GetLayer retrieves layer by diff id. Returns a reader of the uncompressed contents of the layer.
 	GetLayer(diffID string) (io.ReadCloser, error)
+	Healthcheck() (*v1.HealthConfig, error)
+	// History returns the history of the image, holding an entry for each layer and for each instruction not creating a layer.
+	History() ([]v1.History, error)
 	Identifier() (Identifier, error)
 	Label(string) (string, error)
 	Labels() (map[string]string, error)
+	// LayerDescriptors returns the descriptors of the layers of the image, from the bottom layer to the top layer.
+	LayerDescriptors() ([]LayerDescriptor, error)
 	// ManifestSize returns the size of the manifest. If a manifest doesn't exist, it returns 0.
 	ManifestSize() (int64, error)
 	Name() string
 	OS() (string, error)
 	OSVersion() (string, error)
+	Shell() ([]string, error)
+	StopSignal() (string, error)
 	// TopLayer returns the diff id for the top layer
 	TopLayer() (string, error)
+	User() (string, error)
 	Variant() (string, error)
+	Volumes() (map[string]struct{}, error)
 	WorkingDir() (string, error)
 
 	// setters
 
 	// AnnotateRefName set a value for the `org.opencontainers.image.ref.name` annotation
 	AnnotateRefName(refName string) error
+	// AppendEnv appends a value to the list held by an environment variable, such as PATH, using the list separator of the image OS.
+	AppendEnv(key, val string) error
+	// PrependEnv prepends a value to the list held by an environment variable, such as PATH, using the list separator of the image OS.
+	PrependEnv(key, val string) error
 	Rename(name string)
+	// SetAnnotation sets an annotation on the image manifest.
+	SetAnnotation(key, val string) error
 	SetArchitecture(string) error
 	SetCmd(...string) error
 	SetEntrypoint(...string) error
 	SetEnv(string, string) error
+	SetExposedPorts(map[string]struct{}) error
+	SetHealthcheck(*v1.HealthConfig) error
+	// SetHistory replaces the history of the image. The history is only saved with the image when it is created
+	// with the WithHistory option of its backend.
+	SetHistory([]v1.History) error
 	SetLabel(string, string) error
 	SetOS(string) error
 	SetOSVersion(string) error
+	SetShell(...string) error
+	SetStopSignal(string) error
+	SetUser(string) error
 	SetVariant(string) error
+	SetVolumes(map[string]struct{}) error
 	SetWorkingDir(string) error
 
 	// modifiers
 
 	AddLayer(path string) error
 	AddLayerWithDiffID(path, diffID string) error
+	// AddLayerWithDiffIDAndHistory adds a layer like AddLayerWithDiffID, recording the provided history entry for it.
+	AddLayerWithDiffIDAndHistory(path, diffID string, history v1.History) error
+	// Close removes the temporary files created by the image, such as layers fetched from a daemon or archives written
+	// by SaveFile. The image must not be used once closed.
+	Close() error
 	Delete() error
 	Rebase(string, Image) error
+	RemoveAnnotation(key string) error
+	RemoveEnv(key string) error
 	RemoveLabel(string) error
+	// RemoveLayer removes the layer with the provided diff ID, along with its history entry.
+	RemoveLayer(diffID string) error
+	// ReplaceLayer replaces the layer with the provided diff ID with the uncompressed tarred layer at the provided path,
+	// keeping its position and its history entry.
+	ReplaceLayer(diffID, path string) error
 	ReuseLayer(diffID string) error
+	// ReuseLayerWithHistory reuses a layer like ReuseLayer, recording the provided history entry for it.
+	ReuseLayerWithHistory(diffID string, history v1.History) error
 	// Save saves the image as `Name()` and any additional names provided to this method.
 	Save(additionalNames ...string) error
 	// SaveAs ignores the image `Name()` method and saves the image according to name & additional names provided to this method
 	SaveAs(name string, additionalNames ...string) error
-	// SaveFile saves the image as a docker archive and provides the filesystem location
+	// SaveFile saves the image as an archive and provides the filesystem location:
+	// a docker archive for local images and an OCI archive for layout images
 	SaveFile() (string, error)
 }
 
 type Identifier fmt.Stringer
 
-// Platform represents the target arch/os/os_version for an image construction and querying.
+// ImageRefNameKey is the annotation holding the ref name of an image, see Image.AnnotateRefName
+const ImageRefNameKey = "org.opencontainers.image.ref.name"
+
+// BaseImageNameKey and BaseImageDigestKey are the annotations recording the base image of an image,
+// written when an image is created with the WithBaseImageAnnotations option of its backend.
+const (
+	BaseImageNameKey   = "org.opencontainers.image.base.name"
+	BaseImageDigestKey = "org.opencontainers.image.base.digest"
+)
+
+// Platform represents the target arch/os/os_version/variant for an image construction and querying.
 type Platform struct {
 	Architecture string
 	OS           string
 	OSVersion    string
+	Variant      string
 }
 
 type MediaTypes int
@@ -137,6 +198,9 @@ func OverrideMediaTypes(base v1.Image, mediaTypes MediaTypes) (v1.Image, error)
 		return nil, err
 	}
 	config.RootFS.DiffIDs = make([]v1.Hash, 0)
+	// appending the layers adds a history entry for each of them, restore the history of the base afterwards
+	history := config.History
+	config.History = nil
 	image, err = mutate.ConfigFile(image, config)
 	if err != nil {
 		return nil, err
@@ -156,7 +220,24 @@ func OverrideMediaTypes(base v1.Image, mediaTypes MediaTypes) (v1.Image, error)
 		return nil, err
 	}
 
-	return image, nil
+	config, err = image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config = config.DeepCopy()
+	config.History = history
+	return mutate.ConfigFile(image, config)
+}
+
+// NormalizedHistory returns a copy of the provided history with the creation time of each entry set to createdAt,
+// so that images built from the same inputs have the same config.
+func NormalizedHistory(history []v1.History, createdAt time.Time) []v1.History {
+	normalized := make([]v1.History, len(history))
+	for j, h := range history {
+		h.Created = v1.Time{Time: createdAt}
+		normalized[j] = h
+	}
+	return normalized
 }
 
 // layersAddendum creates an Addendum array with the given layers
@@ -172,6 +253,104 @@ func layersAddendum(layers []v1.Layer, mediaType types.MediaType) []mutate.Adden
 	return additions
 }
 
+// ManifestAnnotations returns a copy of the manifest annotations of the provided v1.Image.
+func ManifestAnnotations(image v1.Image) (map[string]string, error) {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	annotations := make(map[string]string, len(manifest.Annotations))
+	for k, v := range manifest.Annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
+// SetBaseImageAnnotations records the provided base image name and manifest digest in the provided annotations or labels.
+// Empty values remove the previously recorded ones, so that they never refer to another base image.
+func SetBaseImageAnnotations(annotations map[string]string, name, digest string) {
+	for key, val := range map[string]string{BaseImageNameKey: name, BaseImageDigestKey: digest} {
+		if val == "" {
+			delete(annotations, key)
+			continue
+		}
+		annotations[key] = val
+	}
+}
+
+// AnnotateBaseImage records the provided base image name and the manifest digest of the provided base image
+// in the provided annotations, see SetBaseImageAnnotations.
+func AnnotateBaseImage(annotations map[string]string, baseImage v1.Image, name string) error {
+	digest, err := baseImage.Digest()
+	if err != nil {
+		return err
+	}
+	SetBaseImageAnnotations(annotations, name, digest.String())
+	return nil
+}
+
+// ManifestDigest returns the manifest digest held by the identifier of the provided image, such as for remote and layout images,
+// or an empty string when the identifier does not hold one, such as for images in a docker daemon.
+func ManifestDigest(image Image) (string, error) {
+	identifier, err := image.Identifier()
+	if err != nil {
+		return "", err
+	}
+	id := identifier.String()
+	idx := strings.LastIndex(id, "@")
+	if idx == -1 {
+		return "", nil
+	}
+	digest, err := v1.NewHash(id[idx+1:])
+	if err != nil {
+		return "", nil
+	}
+	return digest.String(), nil
+}
+
+// WithManifestAnnotations returns a v1.Image wrapping the provided base image, whose manifest annotations are replaced
+// by the provided annotations. Unlike mutate.Annotations, keys missing from the provided map are removed.
+func WithManifestAnnotations(base v1.Image, annotations map[string]string) v1.Image {
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	if annotated, ok := base.(*annotatedImage); ok {
+		base = annotated.Image
+	}
+	return &annotatedImage{Image: base, annotations: annotations}
+}
+
+type annotatedImage struct {
+	v1.Image
+	annotations map[string]string
+}
+
+func (i *annotatedImage) Manifest() (*v1.Manifest, error) {
+	manifest, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	manifest = manifest.DeepCopy()
+	manifest.Annotations = i.annotations
+	return manifest, nil
+}
+
+func (i *annotatedImage) RawManifest() ([]byte, error) {
+	manifest, err := i.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(manifest)
+}
+
+func (i *annotatedImage) Digest() (v1.Hash, error) {
+	return partial.Digest(i)
+}
+
+func (i *annotatedImage) Size() (int64, error) {
+	return partial.Size(i)
+}
+
 var NormalizedDateTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
 
 type SaveDiagnostic struct {
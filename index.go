@@ -0,0 +1,213 @@
+package imgutil
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ImageIndex represents a multi-platform image, i.e. an OCI image index or a Docker manifest list,
+// whose manifests each describe the image for a single platform.
+type ImageIndex interface {
+	// getters
+
+	Annotations() (map[string]string, error)
+	// Found tells whether the index exists in the repository by `Name()`.
+	Found() bool
+	Identifier() (Identifier, error)
+	// Manifests returns the descriptors of the manifests referenced by the index.
+	Manifests() ([]v1.Descriptor, error)
+	MediaType() (types.MediaType, error)
+	Name() string
+
+	// setters
+
+	RemoveAnnotation(key string) error
+	Rename(name string)
+	SetAnnotation(key, val string) error
+
+	// modifiers
+
+	// AddManifest adds the given image to the index, describing it with the platform found in its config file
+	// and the annotations of its manifest.
+	// A manifest already in the index for the same platform is replaced.
+	AddManifest(image v1.Image) error
+	Delete() error
+	// RemoveManifest removes the manifest with the given digest from the index.
+	RemoveManifest(digest string) error
+	// Save saves the index as `Name()` and any additional names provided to this method.
+	Save(additionalNames ...string) error
+	// SaveAs ignores the index `Name()` method and saves the index according to name & additional names provided to this method
+	SaveAs(name string, additionalNames ...string) error
+}
+
+func (t MediaTypes) IndexType() types.MediaType {
+	switch t {
+	case OCITypes:
+		return types.OCIImageIndex
+	case DockerTypes:
+		return types.DockerManifestList
+	default:
+		return ""
+	}
+}
+
+// PlatformDescriptor returns the v1.Platform recorded in the config file of the provided v1.Image.
+func PlatformDescriptor(image v1.Image) (*v1.Platform, error) {
+	cfg, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image")
+	}
+	if cfg.OS == "" || cfg.Architecture == "" {
+		return nil, fmt.Errorf("missing OS or Architecture for image")
+	}
+	osFeatures, err := configOSFeatures(image)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Platform{
+		Architecture: cfg.Architecture,
+		OS:           cfg.OS,
+		OSVersion:    cfg.OSVersion,
+		Variant:      cfg.Variant,
+		OSFeatures:   osFeatures,
+	}, nil
+}
+
+// configOSFeatures returns the OS features recorded in the config file of the provided v1.Image,
+// which v1.ConfigFile does not expose.
+func configOSFeatures(image v1.Image) ([]string, error) {
+	raw, err := image.RawConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		OSFeatures []string `json:"os.features,omitempty"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.OSFeatures, nil
+}
+
+// AppendManifest returns a v1.ImageIndex with the provided v1.Image added to the provided base index.
+// Any manifest in the base index for the same platform, or with the same digest, is replaced.
+// The annotations of the image manifest, such as its ref name, are carried on its descriptor in the index.
+func AppendManifest(base v1.ImageIndex, image v1.Image) (v1.ImageIndex, error) {
+	platform, err := PlatformDescriptor(image)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	index := mutate.RemoveManifests(base, func(desc v1.Descriptor) bool {
+		return desc.Digest == digest || (desc.Platform != nil && desc.Platform.Equals(*platform))
+	})
+	return mutate.AppendManifests(index, mutate.IndexAddendum{
+		Add: image,
+		Descriptor: v1.Descriptor{
+			Platform:    platform,
+			Annotations: manifest.Annotations,
+		},
+	}), nil
+}
+
+// RemoveManifest returns a v1.ImageIndex without the manifest with the provided digest.
+func RemoveManifest(base v1.ImageIndex, digest string) (v1.ImageIndex, error) {
+	hash, err := v1.NewHash(digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := base.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range manifest.Manifests {
+		if desc.Digest == hash {
+			return mutate.RemoveManifests(base, match.Digests(hash)), nil
+		}
+	}
+	return nil, fmt.Errorf("index does not have manifest with digest %q", digest)
+}
+
+// IndexAnnotations returns a copy of the annotations of the provided v1.ImageIndex.
+func IndexAnnotations(index v1.ImageIndex) (map[string]string, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	annotations := make(map[string]string, len(manifest.Annotations))
+	for k, v := range manifest.Annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
+// WithIndexAnnotations returns a v1.ImageIndex wrapping the provided base index, whose annotations are replaced
+// by the provided annotations. Unlike mutate.Annotations, keys missing from the provided map are removed.
+func WithIndexAnnotations(base v1.ImageIndex, annotations map[string]string) v1.ImageIndex {
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	if annotated, ok := base.(*annotatedIndex); ok {
+		base = annotated.base
+	}
+	return &annotatedIndex{base: base, annotations: annotations}
+}
+
+type annotatedIndex struct {
+	base        v1.ImageIndex
+	annotations map[string]string
+}
+
+func (i *annotatedIndex) MediaType() (types.MediaType, error) {
+	return i.base.MediaType()
+}
+
+func (i *annotatedIndex) Image(h v1.Hash) (v1.Image, error) {
+	return i.base.Image(h)
+}
+
+func (i *annotatedIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	return i.base.ImageIndex(h)
+}
+
+func (i *annotatedIndex) IndexManifest() (*v1.IndexManifest, error) {
+	manifest, err := i.base.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	manifest = manifest.DeepCopy()
+	manifest.Annotations = i.annotations
+	return manifest, nil
+}
+
+func (i *annotatedIndex) RawManifest() ([]byte, error) {
+	manifest, err := i.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(manifest)
+}
+
+func (i *annotatedIndex) Digest() (v1.Hash, error) {
+	return partial.Digest(i)
+}
+
+func (i *annotatedIndex) Size() (int64, error) {
+	return partial.Size(i)
+}
@@ -0,0 +1,184 @@
+package imgutil
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+)
+
+// LayerDescriptor describes a layer of an image, see Image.LayerDescriptors.
+type LayerDescriptor struct {
+	// DiffID is the digest of the uncompressed contents of the layer.
+	DiffID string
+	// Digest and Size are the digest and size of the layer as stored by the image repository,
+	// e.g. compressed in a registry or uncompressed in the docker daemon.
+	Digest    string
+	MediaType types.MediaType
+	Size      int64
+}
+
+// LayerDescriptors returns the descriptors of the layers of the provided v1.Image, from the bottom layer to the top layer.
+func LayerDescriptors(image v1.Image) ([]LayerDescriptor, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layers")
+	}
+	descriptors := make([]LayerDescriptor, len(layers))
+	for idx, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting diff ID for layer %d", idx)
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting digest for layer %q", diffID)
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting size for layer %q", diffID)
+		}
+		mediaType, err := layer.MediaType()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting media type for layer %q", diffID)
+		}
+		descriptors[idx] = LayerDescriptor{
+			DiffID:    diffID.String(),
+			Digest:    digest.String(),
+			MediaType: mediaType,
+			Size:      size,
+		}
+	}
+	return descriptors, nil
+}
+
+// RemoveLayer returns a copy of the provided v1.Image without the layer with the provided diff ID.
+// The history entry of the layer is removed as well, when the history holds an entry for each layer.
+func RemoveLayer(image v1.Image, diffID string) (v1.Image, error) {
+	return replaceLayer(image, diffID, nil, "")
+}
+
+// ReplaceLayer returns a copy of the provided v1.Image with the layer with the provided diff ID replaced by the provided layer,
+// with the desired media type, or the media type of the layer if empty. The history entry of the replaced layer is kept.
+func ReplaceLayer(image v1.Image, diffID string, layer v1.Layer, mediaType types.MediaType) (v1.Image, error) {
+	return replaceLayer(image, diffID, layer, mediaType)
+}
+
+// replaceLayer rebuilds the provided v1.Image with the layer with the provided diff ID replaced by the provided layer,
+// or removed if the layer is nil, keeping the media types of the image and of its other layers.
+func replaceLayer(image v1.Image, diffID string, replacement v1.Layer, mediaType types.MediaType) (v1.Image, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting layers")
+	}
+	layerIdx := -1
+	for idx, layer := range layers {
+		layerDiffID, err := layer.DiffID()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting diff ID for layer %d", idx)
+		}
+		if layerDiffID.String() == diffID {
+			layerIdx = idx
+			break
+		}
+	}
+	if layerIdx < 0 {
+		return nil, fmt.Errorf("image does not contain layer with diff ID %q", diffID)
+	}
+
+	manifestType, err := image.MediaType()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting manifest media type")
+	}
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting manifest")
+	}
+	config, err := image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting config file")
+	}
+	config = config.DeepCopy()
+	config.RootFS.DiffIDs = make([]v1.Hash, 0)
+	// appending the layers adds a history entry for each of them, restore the history of the image afterwards
+	history := config.History
+	config.History = nil
+
+	newImage := mutate.MediaType(empty.Image, manifestType)
+	newImage, err = mutate.ConfigFile(newImage, config)
+	if err != nil {
+		return nil, err
+	}
+	newImage = mutate.ConfigMediaType(newImage, manifest.Config.MediaType)
+
+	additions := make([]mutate.Addendum, 0, len(layers))
+	for idx, layer := range layers {
+		if idx == layerIdx {
+			if replacement != nil {
+				additions = append(additions, mutate.Addendum{Layer: replacement, MediaType: mediaType})
+			}
+			continue
+		}
+		layerType, err := layer.MediaType()
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting media type for layer %d", idx)
+		}
+		additions = append(additions, mutate.Addendum{Layer: layer, MediaType: layerType})
+	}
+	newImage, err = mutate.Append(newImage, additions...)
+	if err != nil {
+		return nil, err
+	}
+
+	if replacement == nil && HistoryLayers(history) == len(layers) {
+		history = removeLayerHistory(history, layerIdx)
+	}
+	config, err = newImage.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config = config.DeepCopy()
+	config.History = history
+	return mutate.ConfigFile(newImage, config)
+}
+
+// HistoryLayers returns the number of entries of the provided history that created a layer.
+func HistoryLayers(history []v1.History) int {
+	layers := 0
+	for _, h := range history {
+		if !h.EmptyLayer {
+			layers++
+		}
+	}
+	return layers
+}
+
+// LayersHistory returns a copy of the provided history holding an entry for each of the provided number of layers.
+// When the history holds entries for fewer layers, e.g. for layers added on top of a base image without history,
+// empty entries are added for the bottom layers. When it holds entries for more layers, e.g. when the history
+// of an image was replaced with SetHistory, an empty entry is returned for each layer.
+func LayersHistory(history []v1.History, layers int) []v1.History {
+	historyLayers := HistoryLayers(history)
+	if historyLayers > layers {
+		return make([]v1.History, layers)
+	}
+	return append(make([]v1.History, layers-historyLayers), history...)
+}
+
+// removeLayerHistory returns a copy of the provided history without the entry of the layer at the provided index.
+func removeLayerHistory(history []v1.History, layerIdx int) []v1.History {
+	updated := make([]v1.History, 0, len(history))
+	for _, h := range history {
+		if !h.EmptyLayer {
+			layerIdx--
+			if layerIdx == -1 {
+				continue
+			}
+		}
+		updated = append(updated, h)
+	}
+	return updated
+}
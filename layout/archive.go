@@ -0,0 +1,138 @@
+package layout
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+// WriteArchive writes the OCI layout at the provided path as an OCI archive (oci-archive), i.e. a tar of the
+// `oci-layout`, `index.json` and `blobs` of the layout, as read and written by tools like skopeo and buildah.
+// Entries are written in lexical order with normalized timestamps and ownership, so the same layout always produces
+// the same archive.
+func WriteArchive(layoutPath, archivePath string) error {
+	if !ImageExists(layoutPath) {
+		return fmt.Errorf("no OCI layout at path %q", layoutPath)
+	}
+	f, err := os.Create(filepath.Clean(archivePath))
+	if err != nil {
+		return errors.Wrap(err, "creating archive")
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	err = filepath.Walk(layoutPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(layoutPath, file)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return fmt.Errorf("unsupported file %q in OCI layout", name)
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = imgutil.NormalizedDateTime
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFile(tw, file)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "writing OCI layout at path %q to archive", layoutPath)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ExtractArchive extracts the OCI archive (oci-archive) at the provided path as an OCI layout at the provided path.
+func ExtractArchive(archivePath, layoutPath string) error {
+	f, err := os.Open(filepath.Clean(archivePath))
+	if err != nil {
+		return errors.Wrap(err, "opening archive")
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading archive %q", archivePath)
+		}
+		// cleaning the rooted name keeps the entry in the layout
+		name := path.Clean("/" + header.Name)
+		if name == "/" {
+			continue
+		}
+		target := filepath.Join(layoutPath, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr); err != nil {
+				return errors.Wrapf(err, "extracting %q from archive %q", header.Name, archivePath)
+			}
+		default:
+			return fmt.Errorf("unsupported entry %q in archive %q", header.Name, archivePath)
+		}
+	}
+	if !ImageExists(layoutPath) {
+		return fmt.Errorf("archive %q is not an OCI archive", archivePath)
+	}
+	return nil
+}
+
+func copyFile(w io.Writer, file string) error {
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func writeFile(file string, r io.Reader) error {
+	f, err := os.Create(filepath.Clean(file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil { // #nosec G110
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,174 @@
+package layout
+
+import (
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+var _ imgutil.ImageIndex = (*ImageIndex)(nil)
+
+// ImageIndex is an OCI image index saved on disk as the index.json of an OCI layout,
+// whose manifests each describe the image for a single platform.
+type ImageIndex struct {
+	path  string
+	index v1.ImageIndex
+}
+
+// NewIndex returns a new ImageIndex that can be modified and saved on disk.
+func NewIndex(path string, ops ...IndexOption) (*ImageIndex, error) {
+	indexOpts := &indexOptions{}
+	for _, op := range ops {
+		if err := op(indexOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	ri := &ImageIndex{
+		path:  path,
+		index: emptyIndex(),
+	}
+
+	if indexOpts.baseIndexPath != "" && ImageExists(indexOpts.baseIndexPath) {
+		layoutPath, err := FromPath(indexOpts.baseIndexPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading layout from path new")
+		}
+		ri.index, err = layoutPath.ImageIndex()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading index")
+		}
+	}
+
+	if mediaType := indexOpts.mediaTypes.IndexType(); mediaType != "" {
+		ri.index = mutate.IndexMediaType(ri.index, mediaType)
+	}
+
+	return ri, nil
+}
+
+func emptyIndex() v1.ImageIndex {
+	return mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+}
+
+// getters
+
+func (i *ImageIndex) Annotations() (map[string]string, error) {
+	annotations, err := imgutil.IndexAnnotations(i.index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting annotations for index at path %q", i.path)
+	}
+	return annotations, nil
+}
+
+// Found tells whether the index exists in the repository by `Name()`.
+func (i *ImageIndex) Found() bool {
+	return ImageExists(i.path)
+}
+
+func (i *ImageIndex) Identifier() (imgutil.Identifier, error) {
+	hash, err := i.index.Digest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting identifier for index at path %q", i.path)
+	}
+	return newLayoutIdentifier(i.path, hash)
+}
+
+func (i *ImageIndex) Manifests() ([]v1.Descriptor, error) {
+	manifest, err := i.index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting index manifest for index at path %q", i.path)
+	}
+	return manifest.Manifests, nil
+}
+
+func (i *ImageIndex) MediaType() (types.MediaType, error) {
+	return i.index.MediaType()
+}
+
+func (i *ImageIndex) Name() string {
+	return i.path
+}
+
+// setters
+
+func (i *ImageIndex) RemoveAnnotation(key string) error {
+	annotations, err := i.Annotations()
+	if err != nil {
+		return err
+	}
+	delete(annotations, key)
+	i.index = imgutil.WithIndexAnnotations(i.index, annotations)
+	return nil
+}
+
+func (i *ImageIndex) Rename(name string) {
+	i.path = name
+}
+
+func (i *ImageIndex) SetAnnotation(key, val string) error {
+	annotations, err := i.Annotations()
+	if err != nil {
+		return err
+	}
+	annotations[key] = val
+	i.index = imgutil.WithIndexAnnotations(i.index, annotations)
+	return nil
+}
+
+// modifiers
+
+func (i *ImageIndex) AddManifest(image v1.Image) error {
+	index, err := imgutil.AppendManifest(i.index, image)
+	if err != nil {
+		return errors.Wrapf(err, "adding manifest to index at path %q", i.path)
+	}
+	i.index = index
+	return nil
+}
+
+func (i *ImageIndex) Delete() error {
+	return os.RemoveAll(i.path)
+}
+
+func (i *ImageIndex) RemoveManifest(digest string) error {
+	index, err := imgutil.RemoveManifest(i.index, digest)
+	if err != nil {
+		return errors.Wrapf(err, "removing manifest from index at path %q", i.path)
+	}
+	i.index = index
+	return nil
+}
+
+func (i *ImageIndex) Save(additionalNames ...string) error {
+	return i.SaveAs(i.Name(), additionalNames...)
+}
+
+// SaveAs ignores the index `Name()` method and saves the index according to name & additional names provided to this method
+func (i *ImageIndex) SaveAs(name string, additionalNames ...string) error {
+	var diagnostics []imgutil.SaveDiagnostic
+	pathsToSave := append([]string{name}, additionalNames...)
+	for _, path := range pathsToSave {
+		// initialize index path
+		layoutPath, err := Write(path, empty.Index)
+		if err != nil {
+			return err
+		}
+
+		if err = layoutPath.writeIndex(i.index); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: path, Cause: err})
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package layout_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestLayoutIndex(t *testing.T) {
+	spec.Run(t, "ImageIndex", testImageIndex, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testImageIndex(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir    string
+		indexPath string
+		amd64     *layout.Image
+		arm64     *layout.Image
+		err       error
+	)
+
+	newPlatformImage := func(name string, platform imgutil.Platform) *layout.Image {
+		image, err := layout.NewImage(filepath.Join(tmpDir, name), layout.WithDefaultPlatform(platform))
+		h.AssertNil(t, err)
+		layerPath, _, _ := h.RandomLayer(t, tmpDir)
+		h.AssertNil(t, image.AddLayer(layerPath))
+		h.AssertNil(t, image.Save())
+		return image
+	}
+
+	it.Before(func() {
+		tmpDir, err = os.MkdirTemp("", "layout-index")
+		h.AssertNil(t, err)
+
+		indexPath = filepath.Join(tmpDir, "index")
+		amd64 = newPlatformImage("amd64", imgutil.Platform{OS: "linux", Architecture: "amd64"})
+		arm64 = newPlatformImage("arm64", imgutil.Platform{OS: "linux", Architecture: "arm64"})
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#NewIndex", func() {
+		when("no base index is given", func() {
+			it("returns an empty OCI index", func() {
+				index, err := layout.NewIndex(indexPath)
+				h.AssertNil(t, err)
+
+				manifests, err := index.Manifests()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(manifests), 0)
+
+				mediaType, err := index.MediaType()
+				h.AssertNil(t, err)
+				h.AssertEq(t, mediaType, types.OCIImageIndex)
+				h.AssertEq(t, index.Found(), false)
+			})
+		})
+
+		when("#FromBaseIndexPath", func() {
+			it("loads the manifests and annotations of the existing index", func() {
+				base, err := layout.NewIndex(indexPath)
+				h.AssertNil(t, err)
+				h.AssertNil(t, base.AddManifest(amd64))
+				h.AssertNil(t, base.SetAnnotation("some-key", "some-value"))
+				h.AssertNil(t, base.Save())
+
+				index, err := layout.NewIndex(indexPath, layout.FromBaseIndexPath(indexPath))
+				h.AssertNil(t, err)
+				h.AssertEq(t, index.Found(), true)
+
+				manifests, err := index.Manifests()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(manifests), 1)
+				h.AssertEq(t, manifests[0].Platform.Architecture, "amd64")
+
+				annotations, err := index.Annotations()
+				h.AssertNil(t, err)
+				h.AssertEq(t, annotations["some-key"], "some-value")
+			})
+
+			it("ignores a missing index", func() {
+				index, err := layout.NewIndex(indexPath, layout.FromBaseIndexPath(filepath.Join(tmpDir, "missing")))
+				h.AssertNil(t, err)
+
+				manifests, err := index.Manifests()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(manifests), 0)
+			})
+		})
+
+		when("#WithIndexMediaTypes", func() {
+			it("sets the requested media type", func() {
+				index, err := layout.NewIndex(indexPath, layout.WithIndexMediaTypes(imgutil.DockerTypes))
+				h.AssertNil(t, err)
+
+				mediaType, err := index.MediaType()
+				h.AssertNil(t, err)
+				h.AssertEq(t, mediaType, types.DockerManifestList)
+			})
+		})
+	})
+
+	when("#AddManifest", func() {
+		it("adds a manifest for each platform", func() {
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64))
+			h.AssertNil(t, index.AddManifest(arm64))
+			h.AssertNil(t, index.Save())
+
+			indexManifest := h.ReadIndexManifest(t, indexPath)
+			h.AssertEq(t, len(indexManifest.Manifests), 2)
+			h.AssertEq(t, indexManifest.Manifests[0].Platform.OS, "linux")
+			h.AssertEq(t, indexManifest.Manifests[0].Platform.Architecture, "amd64")
+			h.AssertEq(t, indexManifest.Manifests[1].Platform.OS, "linux")
+			h.AssertEq(t, indexManifest.Manifests[1].Platform.Architecture, "arm64")
+
+			// expected blobs: 2 x (manifest, config, layer)
+			h.AssertBlobsLen(t, indexPath, 6)
+			for _, desc := range indexManifest.Manifests {
+				manifest := h.ReadManifest(t, desc.Digest, indexPath)
+				h.AssertEq(t, len(manifest.Layers), 1)
+			}
+		})
+
+		it("replaces the manifest for the same platform", func() {
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64))
+
+			other := newPlatformImage("other-amd64", imgutil.Platform{OS: "linux", Architecture: "amd64"})
+			h.AssertNil(t, index.AddManifest(other))
+
+			manifests, err := index.Manifests()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(manifests), 1)
+
+			digest, err := other.Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, manifests[0].Digest, digest)
+		})
+	})
+
+	when("#RemoveManifest", func() {
+		it("removes the manifest with the given digest", func() {
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64))
+			h.AssertNil(t, index.AddManifest(arm64))
+
+			digest, err := amd64.Digest()
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.RemoveManifest(digest.String()))
+			h.AssertNil(t, index.Save())
+
+			indexManifest := h.ReadIndexManifest(t, indexPath)
+			h.AssertEq(t, len(indexManifest.Manifests), 1)
+			h.AssertEq(t, indexManifest.Manifests[0].Platform.Architecture, "arm64")
+		})
+
+		it("returns an error for a missing manifest", func() {
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+
+			digest, err := amd64.Digest()
+			h.AssertNil(t, err)
+			h.AssertError(t, index.RemoveManifest(digest.String()), "index does not have manifest with digest")
+		})
+	})
+
+	when("#SetAnnotation #RemoveAnnotation", func() {
+		it("saves the index annotations", func() {
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64))
+			h.AssertNil(t, index.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, index.SetAnnotation("other-key", "other-value"))
+			h.AssertNil(t, index.RemoveAnnotation("other-key"))
+			h.AssertNil(t, index.Save())
+
+			indexManifest := h.ReadIndexManifest(t, indexPath)
+			h.AssertEq(t, indexManifest.Annotations, map[string]string{"some-key": "some-value"})
+			h.AssertEq(t, len(indexManifest.Manifests), 1)
+		})
+	})
+
+	when("#Save", func() {
+		when("additional names are provided", func() {
+			it("saves the index to every path", func() {
+				index, err := layout.NewIndex(indexPath)
+				h.AssertNil(t, err)
+				h.AssertNil(t, index.AddManifest(amd64))
+
+				anotherPath := filepath.Join(tmpDir, "another-index")
+				h.AssertNil(t, index.Save(anotherPath))
+
+				h.AssertEq(t, len(h.ReadIndexManifest(t, indexPath).Manifests), 1)
+				h.AssertEq(t, len(h.ReadIndexManifest(t, anotherPath).Manifests), 1)
+			})
+		})
+	})
+
+	when("#Delete", func() {
+		it("removes the index from disk", func() {
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.Save())
+			h.AssertEq(t, index.Found(), true)
+
+			h.AssertNil(t, index.Delete())
+			h.AssertEq(t, index.Found(), false)
+		})
+	})
+
+	when("an image is loaded from the index with FromBaseImagePath", func() {
+		var imagePath string
+
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "image")
+			index, err := layout.NewIndex(indexPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64))
+			h.AssertNil(t, index.AddManifest(arm64))
+			h.AssertNil(t, index.Save())
+		})
+
+		it("selects the manifest matching the platform", func() {
+			image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(indexPath), layout.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "arm64"}))
+			h.AssertNil(t, err)
+			arch, err := image.Architecture()
+			h.AssertNil(t, err)
+			h.AssertEq(t, arch, "arm64")
+
+			image, err = layout.NewImage(imagePath, layout.FromBaseImagePath(indexPath), layout.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "amd64"}))
+			h.AssertNil(t, err)
+			arch, err = image.Architecture()
+			h.AssertNil(t, err)
+			h.AssertEq(t, arch, "amd64")
+		})
+
+		it("selects the manifest matching the variant", func() {
+			index, err := layout.NewIndex(indexPath, layout.FromBaseIndexPath(indexPath))
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(newPlatformImage("arm-v6", imgutil.Platform{OS: "linux", Architecture: "arm", Variant: "v6"})))
+			h.AssertNil(t, index.AddManifest(newPlatformImage("arm-v7", imgutil.Platform{OS: "linux", Architecture: "arm", Variant: "v7"})))
+			h.AssertNil(t, index.Save())
+
+			image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(indexPath), layout.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+			h.AssertNil(t, err)
+			variant, err := image.Variant()
+			h.AssertNil(t, err)
+			h.AssertEq(t, variant, "v7")
+		})
+
+		it("selects the manifest matching the platform in a nested index", func() {
+			layoutPath, err := layout.FromPath(indexPath)
+			h.AssertNil(t, err)
+			inner, err := layoutPath.ImageIndex()
+			h.AssertNil(t, err)
+			outer := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: inner})
+			nestedPath := filepath.Join(tmpDir, "nested-index")
+			_, err = layout.Write(nestedPath, outer)
+			h.AssertNil(t, err)
+
+			image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(nestedPath), layout.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "arm64"}))
+			h.AssertNil(t, err)
+			arch, err := image.Architecture()
+			h.AssertNil(t, err)
+			h.AssertEq(t, arch, "arm64")
+		})
+
+		when("no manifest matches the platform", func() {
+			it("returns an error listing the available platforms", func() {
+				_, err := layout.NewImage(imagePath, layout.FromBaseImagePath(indexPath), layout.WithDefaultPlatform(imgutil.Platform{OS: "windows", Architecture: "amd64"}))
+				h.AssertError(t, err, `no manifest matching platform "windows/amd64", available platforms: [linux/amd64, linux/arm64]`)
+			})
+		})
+	})
+}
@@ -1,17 +1,16 @@
 package layout
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/pkg/errors"
@@ -28,10 +27,26 @@ type Image struct {
 	createdAt           time.Time
 	refName             string // holds org.opencontainers.image.ref.name value
 	requestedMediaTypes imgutil.MediaTypes
+	annotations         map[string]string // manifest annotations, replacing those of the image on save
+	annotateBaseImage   bool
+	progress            imgutil.ProgressFunc
+	logger              imgutil.Logger
+	layerSource         *layerSource // fetches the layers missing from a sparse image
+	withHistory         bool
+	repository          bool     // the layout at path is shared with other images, see WithRepository
+	tmpPaths            []string // temporary files and directories of the image, removed by Close
 }
 
 // getters
 
+func (i *Image) Annotations() (map[string]string, error) {
+	annotations := make(map[string]string, len(i.annotations))
+	for k, v := range i.annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
 func (i *Image) Architecture() (string, error) {
 	cfg, err := i.Image.ConfigFile()
 	if err != nil {
@@ -46,6 +61,17 @@ func (i *Image) Architecture() (string, error) {
 	return cfg.Architecture, nil
 }
 
+func (i *Image) Cmd() ([]string, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.Cmd, nil
+}
+
 func (i *Image) CreatedAt() (time.Time, error) {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
@@ -62,13 +88,19 @@ func (i *Image) Env(key string) (string, error) {
 	if cfg == nil {
 		return "", fmt.Errorf("missing config for image at path %q", i.path)
 	}
-	for _, envVar := range cfg.Config.Env {
-		parts := strings.Split(envVar, "=")
-		if parts[0] == key {
-			return parts[1], nil
-		}
+	val, _ := imgutil.EnvValue(cfg.Config.Env, cfg.OS, key)
+	return val, nil
+}
+
+func (i *Image) Envs() ([]imgutil.EnvVar, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
 	}
-	return "", nil
+	return imgutil.EnvVars(cfg.Config.Env), nil
 }
 
 func (i *Image) Entrypoint() ([]string, error) {
@@ -82,8 +114,23 @@ func (i *Image) Entrypoint() ([]string, error) {
 	return cfg.Config.Entrypoint, nil
 }
 
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.ExposedPorts, nil
+}
+
 // Found tells whether the image exists in the repository by `Name()`.
+// In a layout repository, it tells whether the layout has a manifest with the ref name of the image.
 func (i *Image) Found() bool {
+	if i.repository {
+		return repositoryImageExists(i.path, i.refName)
+	}
 	return ImageExists(i.path)
 }
 
@@ -116,7 +163,8 @@ func (i *Image) GetAnnotateRefName() (string, error) {
 }
 
 // GetLayer retrieves layer by diff id. Returns a reader of the uncompressed contents of the layer.
-// When the layers (notExistsLayer) came from a sparse image returns an empty reader
+// When the layer (notExistsLayer) is missing from a sparse image, it is fetched from the layer source,
+// or an error is returned if the image has no layer source, see WithLayerSource.
 func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
 	layers, err := i.Image.Layers()
 	if err != nil {
@@ -128,9 +176,37 @@ func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
 		return nil, err
 	}
 
+	if _, ok := layer.(*notExistsLayer); ok {
+		if i.layerSource == nil {
+			return nil, fmt.Errorf("layer %q is missing from image at path %q and no layer source is configured", sha, i.path)
+		}
+		return i.layerSource.uncompressed(layer, i.path)
+	}
 	return layer.Uncompressed()
 }
 
+func (i *Image) Healthcheck() (*v1.HealthConfig, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.Healthcheck, nil
+}
+
+func (i *Image) History() ([]v1.History, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.History, nil
+}
+
 // Identifier
 // Each image's ID is given by the SHA256 hash of its configuration JSON. It is represented as a hexadecimal encoding of 256 bits,
 // e.g., sha256:a9561eb1b190625c9adb5a9513e72c4dedafc1cb2d4c5236c9a6957ec7dfd5a9.
@@ -202,7 +278,7 @@ type notExistsLayer struct {
 }
 
 func (l *notExistsLayer) Compressed() (io.ReadCloser, error) {
-	return io.NopCloser(bytes.NewReader([]byte{})), nil
+	return nil, fmt.Errorf("layer %q has no data in the layout", l.diffID)
 }
 
 func (l *notExistsLayer) DiffID() (v1.Hash, error) {
@@ -210,7 +286,17 @@ func (l *notExistsLayer) DiffID() (v1.Hash, error) {
 }
 
 func (l *notExistsLayer) Uncompressed() (io.ReadCloser, error) {
-	return io.NopCloser(bytes.NewReader([]byte{})), nil
+	return nil, fmt.Errorf("layer %q has no data in the layout", l.diffID)
+}
+
+// LayerDescriptors returns the descriptors of the layers of the image, including the layers missing from a sparse image,
+// which are described by the image manifest.
+func (i *Image) LayerDescriptors() ([]imgutil.LayerDescriptor, error) {
+	descriptors, err := imgutil.LayerDescriptors(i)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting layer descriptors for image at path %q", i.path)
+	}
+	return descriptors, nil
 }
 
 func (i *Image) ManifestSize() (int64, error) {
@@ -246,6 +332,28 @@ func (i *Image) OSVersion() (string, error) {
 	return cfg.OSVersion, nil
 }
 
+func (i *Image) Shell() ([]string, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.Shell, nil
+}
+
+func (i *Image) StopSignal() (string, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return "", errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return "", fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.StopSignal, nil
+}
+
 func (i *Image) TopLayer() (string, error) {
 	all, err := i.Image.Layers()
 	if err != nil {
@@ -262,6 +370,17 @@ func (i *Image) TopLayer() (string, error) {
 	return hex.String(), nil
 }
 
+func (i *Image) User() (string, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return "", errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return "", fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.User, nil
+}
+
 func (i *Image) Variant() (string, error) {
 	cfg, err := i.Image.ConfigFile()
 	if err != nil {
@@ -273,6 +392,17 @@ func (i *Image) Variant() (string, error) {
 	return cfg.Variant, nil
 }
 
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	cfg, err := i.Image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image at path %q", i.path)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image at path %q", i.path)
+	}
+	return cfg.Config.Volumes, nil
+}
+
 func (i *Image) WorkingDir() (string, error) {
 	cfg, err := i.Image.ConfigFile()
 	if err != nil {
@@ -291,10 +421,30 @@ func (i *Image) AnnotateRefName(refName string) error {
 	return nil
 }
 
+func (i *Image) AppendEnv(key, val string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.AppendEnv(env, os, key, val)
+	})
+}
+
+func (i *Image) PrependEnv(key, val string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.PrependEnv(env, os, key, val)
+	})
+}
+
 func (i *Image) Rename(name string) {
 	i.path = name
 }
 
+func (i *Image) SetAnnotation(key, val string) error {
+	if i.annotations == nil {
+		i.annotations = map[string]string{}
+	}
+	i.annotations[key] = val
+	return nil
+}
+
 func (i *Image) SetArchitecture(architecture string) error {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
@@ -316,43 +466,66 @@ func (i *Image) SetCmd(cmd ...string) error {
 	return err
 }
 
-func (i *Image) SetEnv(key string, val string) error {
+func (i *Image) SetEnv(key, val string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.SetEnv(env, os, key, val)
+	})
+}
+
+// updateEnv replaces the environment variables of the image with the result of the provided function
+func (i *Image) updateEnv(update func(env []string, os string) []string) error {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
 		return err
 	}
 	config := *configFile.Config.DeepCopy()
-	ignoreCase := configFile.OS == "windows"
-	for idx, e := range config.Env {
-		parts := strings.Split(e, "=")
-		foundKey := parts[0]
-		searchKey := key
-		if ignoreCase {
-			foundKey = strings.ToUpper(foundKey)
-			searchKey = strings.ToUpper(searchKey)
-		}
-		if foundKey == searchKey {
-			config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
-			err = i.mutateConfig(i.Image, config)
-			return err
-		}
+	config.Env = update(config.Env, configFile.OS)
+	return i.mutateConfig(i.Image, config)
+}
+
+func (i *Image) SetEntrypoint(ep ...string) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
 	}
-	config.Env = append(config.Env, fmt.Sprintf("%s=%s", key, val))
+	config := *configFile.Config.DeepCopy()
+	config.Entrypoint = ep
 	err = i.mutateConfig(i.Image, config)
 	return err
 }
 
-func (i *Image) SetEntrypoint(ep ...string) error {
+func (i *Image) SetExposedPorts(ports map[string]struct{}) error {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
 		return err
 	}
 	config := *configFile.Config.DeepCopy()
-	config.Entrypoint = ep
+	config.ExposedPorts = ports
+	err = i.mutateConfig(i.Image, config)
+	return err
+}
+
+func (i *Image) SetHealthcheck(healthcheck *v1.HealthConfig) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Healthcheck = healthcheck
 	err = i.mutateConfig(i.Image, config)
 	return err
 }
 
+func (i *Image) SetHistory(history []v1.History) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile = configFile.DeepCopy()
+	configFile.History = history
+	return i.mutateConfigFile(i.Image, configFile)
+}
+
 func (i *Image) SetLabel(key string, val string) error {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
@@ -390,6 +563,39 @@ func (i *Image) SetOSVersion(osVersion string) error {
 	return err
 }
 
+func (i *Image) SetShell(shell ...string) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Shell = shell
+	err = i.mutateConfig(i.Image, config)
+	return err
+}
+
+func (i *Image) SetStopSignal(signal string) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.StopSignal = signal
+	err = i.mutateConfig(i.Image, config)
+	return err
+}
+
+func (i *Image) SetUser(user string) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.User = user
+	err = i.mutateConfig(i.Image, config)
+	return err
+}
+
 func (i *Image) SetVariant(variant string) error {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
@@ -400,6 +606,17 @@ func (i *Image) SetVariant(variant string) error {
 	return err
 }
 
+func (i *Image) SetVolumes(volumes map[string]struct{}) error {
+	configFile, err := i.Image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Volumes = volumes
+	err = i.mutateConfig(i.Image, config)
+	return err
+}
+
 func (i *Image) SetWorkingDir(dir string) error {
 	configFile, err := i.Image.ConfigFile()
 	if err != nil {
@@ -419,12 +636,13 @@ func (i *Image) AddLayer(path string) error {
 	if err != nil {
 		return err
 	}
-	return i.addLayer(layer)
+	return i.addLayer(layer, v1.History{})
 }
 
-// addLayer appends the provided layer with the desired media type
-func (i *Image) addLayer(layer v1.Layer) error {
+// addLayer appends the provided layer with the desired media type and the provided history entry
+func (i *Image) addLayer(layer v1.Layer, history v1.History) error {
 	additions := layersAddendum([]v1.Layer{layer}, i.requestedMediaTypes.LayerType())
+	additions[0].History = history
 	image, err := mutate.Append(i.Image, additions...)
 	if err != nil {
 		return errors.Wrap(err, "add layer")
@@ -451,12 +669,79 @@ func (i *Image) AddLayerWithDiffID(path, diffID string) error {
 	return i.AddLayer(path)
 }
 
+func (i *Image) AddLayerWithDiffIDAndHistory(path, diffID string, history v1.History) error {
+	// this is equivalent to AddLayer in the layout case, see AddLayerWithDiffID
+	layer, err := tarball.LayerFromFile(path)
+	if err != nil {
+		return err
+	}
+	return i.addLayer(layer, history)
+}
+
+// Close removes the temporary files created by the image, i.e. the base image extracted from an archive
+// and the archives written by SaveFile. The image must not be used once closed.
+func (i *Image) Close() error {
+	var err error
+	for _, tmpPath := range i.tmpPaths {
+		if removeErr := os.RemoveAll(tmpPath); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	i.tmpPaths = nil
+	return err
+}
+
+// Delete removes the layout of the image, or only the manifest with the ref name of the image in a layout repository.
 func (i *Image) Delete() error {
+	if i.repository {
+		if !ImageExists(i.path) {
+			return nil
+		}
+		layoutPath, err := FromPath(i.path)
+		if err != nil {
+			return errors.Wrapf(err, "loading layout repository at path %q", i.path)
+		}
+		return layoutPath.RemoveDescriptors(match.Name(i.refName))
+	}
 	return os.RemoveAll(i.path)
 }
 
-func (i *Image) Rebase(s string, image imgutil.Image) error {
-	return errors.New("not yet implemented")
+// Rebase replaces the layers of the image up to and including baseTopLayer with the layers of newBase,
+// and adopts the OS, architecture and OS version of newBase.
+// newBase can be an image from any backend exposing its config and layers, see imgutil.V1Image.
+func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
+	newBaseImage, err := imgutil.V1Image(newBase)
+	if err != nil {
+		return errors.Wrap(err, "reading new base")
+	}
+
+	newImage, err := imgutil.RebaseV1Image(i, baseTopLayer, newBaseImage)
+	if err != nil {
+		return err
+	}
+	if err = i.setUnderlyingImage(newImage); err != nil {
+		return err
+	}
+
+	if i.annotateBaseImage {
+		digest, err := imgutil.ManifestDigest(newBase)
+		if err != nil {
+			return errors.Wrapf(err, "getting digest for new base image %q", newBase.Name())
+		}
+		imgutil.SetBaseImageAnnotations(i.annotations, newBase.Name(), digest)
+	}
+	return nil
+}
+
+func (i *Image) RemoveAnnotation(key string) error {
+	delete(i.annotations, key)
+	return nil
+}
+
+func (i *Image) RemoveEnv(key string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.RemoveEnv(env, os, key)
+	})
 }
 
 func (i *Image) RemoveLabel(key string) error {
@@ -473,12 +758,52 @@ func (i *Image) RemoveLabel(key string) error {
 	return err
 }
 
+func (i *Image) RemoveLayer(diffID string) error {
+	image, err := imgutil.RemoveLayer(i, diffID)
+	if err != nil {
+		return errors.Wrapf(err, "removing layer from image at path %q", i.path)
+	}
+	return i.setUnderlyingImage(image)
+}
+
+func (i *Image) ReplaceLayer(diffID, path string) error {
+	layer, err := tarball.LayerFromFile(path)
+	if err != nil {
+		return err
+	}
+	image, err := imgutil.ReplaceLayer(i, diffID, layer, i.requestedMediaTypes.LayerType())
+	if err != nil {
+		return errors.Wrapf(err, "replacing layer of image at path %q", i.path)
+	}
+	return i.setUnderlyingImage(image)
+}
+
 func (i *Image) ReuseLayer(sha string) error {
+	return i.ReuseLayerWithHistory(sha, v1.History{})
+}
+
+func (i *Image) ReuseLayerWithHistory(sha string, history v1.History) error {
 	layer, err := findLayerWithSha(i.prevLayers, sha)
 	if err != nil {
 		return err
 	}
-	return i.addLayer(layer)
+	return i.addLayer(layer, history)
+}
+
+// extras
+
+// SetUnderlyingImage replaces the config and layers of the image with those of the provided image, along with its
+// manifest annotations, e.g. to write an image from another backend to the layout, see imgutil.Copy.
+func (i *Image) SetUnderlyingImage(image v1.Image) error {
+	annotations, err := imgutil.ManifestAnnotations(image)
+	if err != nil {
+		return errors.Wrap(err, "reading manifest annotations")
+	}
+	if err := i.setUnderlyingImage(image); err != nil {
+		return err
+	}
+	i.annotations = annotations
+	return nil
 }
 
 // helpers
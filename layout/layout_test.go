@@ -1,13 +1,24 @@
 package layout_test
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1/types"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 
 	"github.com/buildpacks/imgutil"
@@ -15,6 +26,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 
 	"github.com/buildpacks/imgutil/layout"
+	imgremote "github.com/buildpacks/imgutil/remote"
 
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
@@ -237,9 +249,9 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 					h.AssertNil(t, err)
 					h.AssertEq(t, arch, "amd64")
 
-					readCloser, err := img.GetLayer(existingLayerSha)
-					h.AssertNil(t, err)
-					defer readCloser.Close()
+					// the layer is missing from the sparse image
+					_, err = img.GetLayer(existingLayerSha)
+					h.AssertError(t, err, "no layer source is configured")
 				})
 			})
 
@@ -492,6 +504,47 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Envs #RemoveEnv #AppendEnv #PrependEnv", func() {
+		var image *layout.Image
+
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "envs-image")
+			image, err = layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+		})
+
+		it.After(func() {
+			os.RemoveAll(imagePath)
+		})
+
+		it("environment variables are managed in order and saved on disk in OCI layout format", func() {
+			h.AssertNil(t, image.SetEnv("PATH", "/usr/bin"))
+			h.AssertNil(t, image.SetEnv("JAVA_OPTS", "-Dx=y"))
+			h.AssertNil(t, image.SetEnv("REMOVED", "some-val"))
+			h.AssertNil(t, image.AppendEnv("PATH", "/app/bin"))
+			h.AssertNil(t, image.PrependEnv("PATH", "/layers/bin"))
+			h.AssertNil(t, image.RemoveEnv("REMOVED"))
+
+			err := image.Save()
+			h.AssertNil(t, err)
+
+			_, configFile := h.ReadManifestAndConfigFile(t, imagePath)
+			h.AssertEq(t, configFile.Config.Env, []string{"PATH=/layers/bin:/usr/bin:/app/bin", "JAVA_OPTS=-Dx=y"})
+
+			imageLoaded, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			value, err := imageLoaded.Env("JAVA_OPTS")
+			h.AssertNil(t, err)
+			h.AssertEq(t, value, "-Dx=y")
+			envs, err := imageLoaded.Envs()
+			h.AssertNil(t, err)
+			h.AssertEq(t, envs, []imgutil.EnvVar{
+				{Key: "PATH", Value: "/layers/bin:/usr/bin:/app/bin"},
+				{Key: "JAVA_OPTS", Value: "-Dx=y"},
+			})
+		})
+	})
+
 	when("#Name", func() {
 		it("always returns the original name", func() {
 			img, err := layout.NewImage(imagePath)
@@ -565,6 +618,51 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetAnnotation #RemoveAnnotation", func() {
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "new-set-annotation-image")
+		})
+
+		it.After(func() {
+			os.RemoveAll(imagePath)
+		})
+
+		it("saves the annotations on the manifest", func() {
+			img, err := layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.source", "https://example.com/some-repo"))
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.revision", "some-revision"))
+			h.AssertNil(t, img.RemoveAnnotation("org.opencontainers.image.revision"))
+
+			h.AssertNil(t, img.Save())
+
+			index := h.ReadIndexManifest(t, imagePath)
+			manifest := h.ReadManifest(t, index.Manifests[0].Digest, imagePath)
+			h.AssertEq(t, manifest.Annotations, map[string]string{"org.opencontainers.image.source": "https://example.com/some-repo"})
+
+		})
+
+		it("does not inherit the annotations of the base image", func() {
+			baseImg, err := layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, baseImg.SetAnnotation("org.opencontainers.image.source", "https://example.com/some-repo"))
+			h.AssertNil(t, baseImg.Save())
+
+			testImagePath := filepath.Join(tmpDir, "new-test-image")
+			testImg, err := layout.NewImage(testImagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			annotations, err := testImg.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{})
+
+			h.AssertNil(t, testImg.Save())
+			index := h.ReadIndexManifest(t, testImagePath)
+			manifest := h.ReadManifest(t, index.Manifests[0].Digest, testImagePath)
+			h.AssertEq(t, len(manifest.Annotations), 0)
+		})
+	})
+
 	when("#RemoveLabel", func() {
 		it.Before(func() {
 			imagePath = filepath.Join(tmpDir, "new-remove-label-image")
@@ -649,6 +747,48 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetUser #SetExposedPorts #SetVolumes #SetStopSignal #SetShell #SetHealthcheck", func() {
+		var image *layout.Image
+
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "set-runtime-config-image")
+			image, err = layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+		})
+
+		it.After(func() {
+			os.RemoveAll(imagePath)
+		})
+
+		it("the runtime config is saved on disk in OCI layout format", func() {
+			healthcheck := &v1.HealthConfig{Test: []string{"CMD", "some-check"}, Interval: time.Second, Retries: 3}
+			h.AssertNil(t, image.SetUser("some-user"))
+			h.AssertNil(t, image.SetExposedPorts(map[string]struct{}{"8080/tcp": {}}))
+			h.AssertNil(t, image.SetVolumes(map[string]struct{}{"/some/volume": {}}))
+			h.AssertNil(t, image.SetStopSignal("SIGKILL"))
+			h.AssertNil(t, image.SetShell("/bin/bash", "-c"))
+			h.AssertNil(t, image.SetHealthcheck(healthcheck))
+
+			err = image.Save()
+			h.AssertNil(t, err)
+
+			_, configFile := h.ReadManifestAndConfigFile(t, imagePath)
+			h.AssertEq(t, configFile.Config.User, "some-user")
+			h.AssertEq(t, configFile.Config.ExposedPorts, map[string]struct{}{"8080/tcp": {}})
+			h.AssertEq(t, configFile.Config.Volumes, map[string]struct{}{"/some/volume": {}})
+			h.AssertEq(t, configFile.Config.StopSignal, "SIGKILL")
+			h.AssertEq(t, configFile.Config.Shell, []string{"/bin/bash", "-c"})
+			h.AssertEq(t, configFile.Config.Healthcheck, healthcheck)
+
+			user, err := image.User()
+			h.AssertNil(t, err)
+			h.AssertEq(t, user, "some-user")
+			shell, err := image.Shell()
+			h.AssertNil(t, err)
+			h.AssertEq(t, shell, []string{"/bin/bash", "-c"})
+		})
+	})
+
 	when("#TopLayer", func() {
 		it.Before(func() {
 			imagePath = filepath.Join(tmpDir, "top-layer-from-base-image-path")
@@ -863,6 +1003,161 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("#WithHistory", func() {
+			var baseImagePath string
+
+			it.Before(func() {
+				baseImagePath = filepath.Join(tmpDir, "save-with-history-base")
+				imagePath = filepath.Join(tmpDir, "save-with-history")
+
+				baseImage, err := layout.NewImage(baseImagePath, layout.WithHistory())
+				h.AssertNil(t, err)
+				layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+				h.AssertNil(t, baseImage.AddLayerWithDiffIDAndHistory(layerPath, diffID, v1.History{CreatedBy: "base-layer", Author: "some-author"}))
+				history, err := baseImage.History()
+				h.AssertNil(t, err)
+				h.AssertNil(t, baseImage.SetHistory(append(history, v1.History{CreatedBy: "ENV", EmptyLayer: true})))
+				h.AssertNil(t, baseImage.Save())
+			})
+
+			it("keeps the history of the base image and of the added layers with normalized timestamps", func() {
+				image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(baseImagePath), layout.WithHistory())
+				h.AssertNil(t, err)
+				layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+				h.AssertNil(t, image.AddLayerWithDiffIDAndHistory(layerPath, diffID, v1.History{CreatedBy: "app-layer", Comment: "some-comment"}))
+
+				h.AssertNil(t, image.Save())
+
+				savedImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+				h.AssertNil(t, err)
+				history, err := savedImage.History()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(history), 3)
+				h.AssertEq(t, history[0].CreatedBy, "base-layer")
+				h.AssertEq(t, history[0].Author, "some-author")
+				h.AssertEq(t, history[1].CreatedBy, "ENV")
+				h.AssertEq(t, history[1].EmptyLayer, true)
+				h.AssertEq(t, history[2].CreatedBy, "app-layer")
+				h.AssertEq(t, history[2].Comment, "some-comment")
+				for _, entry := range history {
+					h.AssertEq(t, entry.Created.Time, imgutil.NormalizedDateTime)
+				}
+			})
+
+			it("keeps the history when the media types are changed", func() {
+				image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(baseImagePath), layout.WithHistory(), layout.WithMediaTypes(imgutil.DockerTypes))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, image.Save())
+
+				savedImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+				h.AssertNil(t, err)
+				history, err := savedImage.History()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(history), 2)
+				h.AssertEq(t, history[0].CreatedBy, "base-layer")
+				h.AssertEq(t, history[1].CreatedBy, "ENV")
+			})
+
+			it("adds empty entries for the layers of a base image without history", func() {
+				base, err := random.Image(1024, 3)
+				h.AssertNil(t, err)
+				configFile, err := base.ConfigFile()
+				h.AssertNil(t, err)
+				configFile = configFile.DeepCopy()
+				configFile.History = nil
+				base, err = mutate.ConfigFile(base, configFile)
+				h.AssertNil(t, err)
+
+				image, err := layout.NewImage(imagePath, layout.FromBaseImage(base), layout.WithHistory())
+				h.AssertNil(t, err)
+				layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+				h.AssertNil(t, image.AddLayerWithDiffIDAndHistory(layerPath, diffID, v1.History{CreatedBy: "app-layer"}))
+				h.AssertNil(t, image.Save())
+
+				savedImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+				h.AssertNil(t, err)
+				history, err := savedImage.History()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(history), 4)
+				h.AssertEq(t, history[0].CreatedBy, "")
+				h.AssertEq(t, history[3].CreatedBy, "app-layer")
+			})
+
+			when("the image is created without it", func() {
+				it("saves an empty entry for each layer", func() {
+					image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(baseImagePath))
+					h.AssertNil(t, err)
+
+					h.AssertNil(t, image.Save())
+
+					savedImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+					h.AssertNil(t, err)
+					history, err := savedImage.History()
+					h.AssertNil(t, err)
+					h.AssertEq(t, history, []v1.History{{Created: v1.Time{Time: imgutil.NormalizedDateTime}}})
+				})
+			})
+		})
+
+		when("#WithLogger", func() {
+			it.Before(func() {
+				imagePath = filepath.Join(tmpDir, "save-with-logger")
+			})
+
+			it("logs the blobs that already exist", func() {
+				image, err := layout.NewImage(imagePath)
+				h.AssertNil(t, err)
+				layerPath, _, _ := h.RandomLayer(t, tmpDir)
+				h.AssertNil(t, image.AddLayer(layerPath))
+				h.AssertNil(t, image.Save())
+
+				logger := &h.Logger{}
+				image, err = layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath), layout.WithLogger(logger))
+				h.AssertNil(t, err)
+				h.AssertNil(t, image.Save())
+
+				h.AssertContains(t, logger.Messages(), "skipping blob that already exists")
+			})
+		})
+
+		when("#WithProgress", func() {
+			it.Before(func() {
+				imagePath = filepath.Join(tmpDir, "save-with-progress")
+			})
+
+			it("reports the progress of each layer and of the whole image", func() {
+				var updates []imgutil.Progress
+				image, err := layout.NewImage(imagePath, layout.WithProgress(func(p imgutil.Progress) {
+					updates = append(updates, p)
+				}))
+				h.AssertNil(t, err)
+				layerPath, _, _ := h.RandomLayer(t, tmpDir)
+				h.AssertNil(t, image.AddLayer(layerPath))
+
+				h.AssertNil(t, image.Save())
+
+				layers, err := image.Layers()
+				h.AssertNil(t, err)
+				digest, err := layers[0].Digest()
+				h.AssertNil(t, err)
+				size, err := layers[0].Size()
+				h.AssertNil(t, err)
+
+				var layerUpdates []imgutil.Progress
+				for _, update := range updates {
+					if update.Layer != "" {
+						h.AssertEq(t, update.Layer, digest.String())
+						h.AssertEq(t, update.Total, size)
+						layerUpdates = append(layerUpdates, update)
+					}
+				}
+				h.AssertEq(t, layerUpdates[0].Complete, int64(0))
+				h.AssertEq(t, layerUpdates[len(layerUpdates)-1].Complete, size)
+				h.AssertEq(t, updates[len(updates)-1], imgutil.Progress{Complete: size, Total: size})
+			})
+		})
 	})
 
 	when("#Found", func() {
@@ -1053,7 +1348,808 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				// from testdata/layout/busybox-sparse/
 				diffID := "sha256:40cf597a9181e86497f4121c604f9f0ab208950a98ca21db883f26b0a548a2eb"
 				_, err = image.GetLayer(diffID)
+				h.AssertError(t, err, "no layer source is configured")
+			})
+		})
+
+		when("sparse image has a layer source", func() {
+			var (
+				server     *httptest.Server
+				repoName   string
+				sparsePath string
+				diffID     string
+				contents   []byte
+			)
+
+			it.Before(func() {
+				server = httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", log.Lshortfile))))
+				repoName = strings.TrimPrefix(server.URL, "http://") + "/layer-source:latest"
+				sparsePath = filepath.Join(tmpDir, "layer-source-sparse")
+
+				image, err := random.Image(1024, 1)
+				h.AssertNil(t, err)
+				ref, err := name.ParseReference(repoName)
+				h.AssertNil(t, err)
+				h.AssertNil(t, remote.Write(ref, image))
+				layers, err := image.Layers()
+				h.AssertNil(t, err)
+				hash, err := layers[0].DiffID()
+				h.AssertNil(t, err)
+				diffID = hash.String()
+				rc, err := layers[0].Uncompressed()
+				h.AssertNil(t, err)
+				defer rc.Close()
+				contents, err = io.ReadAll(rc)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, layout.Pull(sparsePath, repoName, authn.DefaultKeychain, layout.NoLayers))
+			})
+
+			it.After(func() {
+				server.Close()
+			})
+
+			it("fetches the missing layer from the registry", func() {
+				image, err := layout.NewImage(sparsePath, layout.FromBaseImagePath(sparsePath), layout.WithLayerSource(repoName, authn.DefaultKeychain, false))
+				h.AssertNil(t, err)
+
+				rc, err := image.GetLayer(diffID)
+				h.AssertNil(t, err)
+				defer rc.Close()
+				data, err := io.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertEq(t, data, contents)
+				h.AssertBlobsLen(t, sparsePath, 2)
+			})
+
+			it("writes the fetched layer back to the layout", func() {
+				image, err := layout.NewImage(sparsePath, layout.FromBaseImagePath(sparsePath), layout.WithLayerSource(repoName, authn.DefaultKeychain, true))
+				h.AssertNil(t, err)
+
+				rc, err := image.GetLayer(diffID)
+				h.AssertNil(t, err)
+				defer rc.Close()
+				data, err := io.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertEq(t, data, contents)
+				h.AssertBlobsLen(t, sparsePath, 3)
+
+				// the layer is now read from the layout
+				image, err = layout.NewImage(sparsePath, layout.FromBaseImagePath(sparsePath))
+				h.AssertNil(t, err)
+				rc, err = image.GetLayer(diffID)
+				h.AssertNil(t, err)
+				defer rc.Close()
+				data, err = io.ReadAll(rc)
+				h.AssertNil(t, err)
+				h.AssertEq(t, data, contents)
+			})
+
+			it("fetches the missing layers to save the image as a file", func() {
+				image, err := layout.NewImage(sparsePath, layout.FromBaseImagePath(sparsePath), layout.WithLayerSource(repoName, authn.DefaultKeychain, false))
+				h.AssertNil(t, err)
+				defer image.Close()
+
+				path, err := image.SaveFile()
+				h.AssertNil(t, err)
+				extracted := filepath.Join(tmpDir, "layer-source-extracted")
+				h.AssertNil(t, layout.ExtractArchive(path, extracted))
+				// manifest, config, layer
+				h.AssertBlobsLen(t, extracted, 3)
+			})
+
+			it("fetches the missing layer with the provided context", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				image, err := layout.NewImage(sparsePath, layout.FromBaseImagePath(sparsePath),
+					layout.WithLayerSource(repoName, authn.DefaultKeychain, false, imgremote.WithV1Context(ctx)))
+				h.AssertNil(t, err)
+
+				_, err = image.GetLayer(diffID)
+				h.AssertError(t, err, "context canceled")
+			})
+		})
+
+		when("the image has no layer source", func() {
+			it("returns an error for the compressed contents of a missing layer", func() {
+				sparseImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(sparseBaseImagePath))
+				h.AssertNil(t, err)
+				layers, err := sparseImage.Layers()
+				h.AssertNil(t, err)
+
+				_, err = layers[0].Compressed()
+				h.AssertError(t, err, "has no data in the layout")
+			})
+		})
+	})
+
+	when("#LayerDescriptors #RemoveLayer #ReplaceLayer", func() {
+		var (
+			image                     *layout.Image
+			diffID1, diffID2, diffID3 string
+		)
+
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "edit-layers")
+
+			var err error
+			image, err = layout.NewImage(imagePath, layout.WithHistory())
+			h.AssertNil(t, err)
+			var layerPath1, layerPath2, layerPath3 string
+			layerPath1, diffID1, _ = h.RandomLayer(t, tmpDir)
+			layerPath2, diffID2, _ = h.RandomLayer(t, tmpDir)
+			layerPath3, diffID3, _ = h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, image.AddLayerWithDiffIDAndHistory(layerPath1, diffID1, v1.History{CreatedBy: "layer-1"}))
+			h.AssertNil(t, image.AddLayerWithDiffIDAndHistory(layerPath2, diffID2, v1.History{CreatedBy: "layer-2"}))
+			h.AssertNil(t, image.AddLayerWithDiffIDAndHistory(layerPath3, diffID3, v1.History{CreatedBy: "layer-3"}))
+		})
+
+		it("describes the layers from the bottom layer to the top layer", func() {
+			descriptors, err := image.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 3)
+			h.AssertEq(t, descriptors[0].DiffID, diffID1)
+			h.AssertEq(t, descriptors[1].DiffID, diffID2)
+			h.AssertEq(t, descriptors[2].DiffID, diffID3)
+			for _, descriptor := range descriptors {
+				h.AssertNotEq(t, descriptor.MediaType, types.MediaType(""))
+				h.AssertNotEq(t, descriptor.Digest, "")
+				h.AssertNotEq(t, descriptor.Size, int64(0))
+			}
+		})
+
+		it("removes and replaces layers along with their history", func() {
+			h.AssertNil(t, image.RemoveLayer(diffID2))
+			newLayerPath, newDiffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, image.ReplaceLayer(diffID1, newLayerPath))
+
+			h.AssertNil(t, image.Save())
+
+			savedImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			descriptors, err := savedImage.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 2)
+			h.AssertEq(t, descriptors[0].DiffID, newDiffID)
+			h.AssertEq(t, descriptors[1].DiffID, diffID3)
+			history, err := savedImage.History()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(history), 2)
+			h.AssertEq(t, history[0].CreatedBy, "layer-1")
+			h.AssertEq(t, history[1].CreatedBy, "layer-3")
+		})
+
+		it("errors when the layer does not exist", func() {
+			h.AssertError(t, image.RemoveLayer("sha256:some-missing-layer"), `image does not contain layer with diff ID "sha256:some-missing-layer"`)
+		})
+
+		when("sparse image was saved on disk in OCI layout format", func() {
+			it("describes the missing layers from the manifest", func() {
+				image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(sparseBaseImagePath))
+				h.AssertNil(t, err)
+
+				descriptors, err := image.LayerDescriptors()
+				h.AssertNil(t, err)
+				// from testdata/layout/busybox-sparse/
+				h.AssertEq(t, descriptors, []imgutil.LayerDescriptor{{
+					DiffID:    "sha256:40cf597a9181e86497f4121c604f9f0ab208950a98ca21db883f26b0a548a2eb",
+					Digest:    "sha256:405fecb6a2fa4f29683f977e7e3b852bf6f8975a2aba647d234d2371894943da",
+					MediaType: types.DockerLayer,
+					Size:      772999,
+				}})
+			})
+		})
+	})
+
+	when("#Squash", func() {
+		var (
+			image                        *layout.Image
+			layerPaths                   []string
+			baseDiffID, squashFromDiffID string
+		)
+
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "squash")
+
+			var err error
+			image, err = layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+
+			var baseLayerPath string
+			baseLayerPath, baseDiffID = h.FilesLayer(t, tmpDir, map[string]string{"dir/base.txt": "base", "gone.txt": "base"})
+			layer1Path, layer1DiffID := h.FilesLayer(t, tmpDir, map[string]string{"a.txt": "layer-1", "dir/x.txt": "layer-1"})
+			layer2Path, _ := h.FilesLayer(t, tmpDir, map[string]string{"a.txt": "layer-2", ".wh.gone.txt": "", "dir/.wh.x.txt": ""})
+			layer3Path, _ := h.FilesLayer(t, tmpDir, map[string]string{"b.txt": "layer-3"})
+			layerPaths = []string{baseLayerPath, layer1Path, layer2Path, layer3Path}
+			for _, layerPath := range layerPaths {
+				h.AssertNil(t, image.AddLayer(layerPath))
+			}
+			squashFromDiffID = layer1DiffID
+		})
+
+		it("merges the layers into a single layer keeping the whiteouts for the lower layers", func() {
+			layerPath, err := imgutil.Squash(image, squashFromDiffID)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			h.AssertNil(t, image.Save())
+
+			savedImage, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			descriptors, err := savedImage.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 2)
+			h.AssertEq(t, descriptors[0].DiffID, baseDiffID)
+
+			layer, err := savedImage.GetLayer(descriptors[1].DiffID)
+			h.AssertNil(t, err)
+			defer layer.Close()
+			h.AssertEq(t, h.LayerFiles(t, layer), map[string]string{
+				"a.txt":         "layer-2",
+				".wh.gone.txt":  "",
+				"dir/.wh.x.txt": "",
+				"b.txt":         "layer-3",
+			})
+		})
+
+		it("drops the whiteouts when squashing all the layers", func() {
+			layerPath, err := imgutil.Squash(image, baseDiffID)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			descriptors, err := image.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 1)
+			layer, err := image.GetLayer(descriptors[0].DiffID)
+			h.AssertNil(t, err)
+			defer layer.Close()
+			h.AssertEq(t, h.LayerFiles(t, layer), map[string]string{
+				"dir/base.txt": "base",
+				"a.txt":        "layer-2",
+				"b.txt":        "layer-3",
+			})
+		})
+
+		it("produces the same layer for the same layers", func() {
+			otherImage, err := layout.NewImage(filepath.Join(tmpDir, "squash-other"))
+			h.AssertNil(t, err)
+			for _, layerPath := range layerPaths {
+				h.AssertNil(t, otherImage.AddLayer(layerPath))
+			}
+
+			layerPath, err := imgutil.Squash(image, squashFromDiffID)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			otherLayerPath, err := imgutil.Squash(otherImage, squashFromDiffID)
+			h.AssertNil(t, err)
+			defer os.Remove(otherLayerPath)
+
+			topLayer, err := image.TopLayer()
+			h.AssertNil(t, err)
+			otherTopLayer, err := otherImage.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, otherTopLayer)
+		})
+
+		it("records a history entry combining the entries of the squashed layers", func() {
+			image, err := layout.NewImage(filepath.Join(tmpDir, "squash-history"), layout.WithHistory())
+			h.AssertNil(t, err)
+			for l, layerPath := range layerPaths {
+				h.AssertNil(t, image.AddLayerWithDiffIDAndHistory(layerPath, h.FileDiffID(t, layerPath), v1.History{CreatedBy: fmt.Sprintf("layer-%d", l)}))
+			}
+
+			layerPath, err := imgutil.Squash(image, squashFromDiffID)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			history, err := image.History()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(history), 2)
+			h.AssertEq(t, history[0].CreatedBy, "layer-0")
+			h.AssertEq(t, history[1].CreatedBy, "layer-1 && layer-2 && layer-3")
+		})
+
+		it("removes every squashed layer when a layer is added again on top", func() {
+			h.AssertNil(t, image.AddLayer(layerPaths[1]))
+
+			layerPath, err := imgutil.Squash(image, squashFromDiffID)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+
+			descriptors, err := image.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 2)
+			h.AssertEq(t, descriptors[0].DiffID, baseDiffID)
+			layer, err := image.GetLayer(descriptors[1].DiffID)
+			h.AssertNil(t, err)
+			defer layer.Close()
+			h.AssertEq(t, h.LayerFiles(t, layer), map[string]string{
+				"a.txt":         "layer-1",
+				".wh.gone.txt":  "",
+				"dir/.wh.x.txt": "",
+				"dir/x.txt":     "layer-1",
+				"b.txt":         "layer-3",
+			})
+		})
+
+		it("errors when the layer does not exist", func() {
+			_, err := imgutil.Squash(image, "sha256:some-missing-layer")
+			h.AssertError(t, err, `does not contain layer with diff ID "sha256:some-missing-layer"`)
+		})
+	})
+
+	when("#Copy", func() {
+		it("copies the config, layers and annotations of an image to another path", func() {
+			srcPath := filepath.Join(tmpDir, "copy-src")
+			imagePath = filepath.Join(tmpDir, "copy-dst")
+
+			srcImage, err := layout.NewImage(srcPath)
+			h.AssertNil(t, err)
+			layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, srcImage.AddLayer(layerPath))
+			h.AssertNil(t, srcImage.SetLabel("some-label", "some-value"))
+			h.AssertNil(t, srcImage.SetAnnotation("saved-annotation", "some-value"))
+			h.AssertNil(t, srcImage.Save())
+
+			src, err := layout.NewImage(srcPath, layout.FromBaseImagePath(srcPath))
+			h.AssertNil(t, err)
+			h.AssertNil(t, src.SetAnnotation("some-annotation", "some-value"))
+			dst, err := layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, imgutil.Copy(src, dst))
+
+			copied, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			topLayer, err := copied.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffID)
+			label, err := copied.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			index := h.ReadIndexManifest(t, imagePath)
+			manifest := h.ReadManifest(t, index.Manifests[0].Digest, imagePath)
+			// the annotations of the base manifest are not those of src
+			h.AssertEq(t, manifest.Annotations, map[string]string{"some-annotation": "some-value"})
+		})
+	})
+
+	when("#Pull", func() {
+		var (
+			server   *httptest.Server
+			repoName string
+			digest   string
+			diffIDs  []string
+		)
+
+		it.Before(func() {
+			server = httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", log.Lshortfile))))
+			repoName = strings.TrimPrefix(server.URL, "http://") + "/pull-test:some-tag"
+			imagePath = filepath.Join(tmpDir, "pull-image")
+
+			image, err := random.Image(1024, 3)
+			h.AssertNil(t, err)
+			ref, err := name.ParseReference(repoName)
+			h.AssertNil(t, err)
+			h.AssertNil(t, remote.Write(ref, image))
+			imageDigest, err := image.Digest()
+			h.AssertNil(t, err)
+			digest = imageDigest.String()
+
+			layers, err := image.Layers()
+			h.AssertNil(t, err)
+			diffIDs = nil
+			for _, layer := range layers {
+				diffID, err := layer.DiffID()
+				h.AssertNil(t, err)
+				diffIDs = append(diffIDs, diffID.String())
+			}
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		it("writes the image with all its layers", func() {
+			h.AssertNil(t, layout.Pull(imagePath, repoName, authn.DefaultKeychain, layout.AllLayers))
+
+			//  expected blobs: manifest, config, layers
+			h.AssertBlobsLen(t, imagePath, 5)
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 1)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "some-tag")
+
+			img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			rc, err := img.GetLayer(diffIDs[1])
+			h.AssertNil(t, err)
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertTrue(t, func() bool { return len(data) > 0 })
+		})
+
+		it("writes a sparse image without layers", func() {
+			h.AssertNil(t, layout.Pull(imagePath, repoName, authn.DefaultKeychain, layout.NoLayers))
+
+			//  expected blobs: manifest, config
+			h.AssertBlobsLen(t, imagePath, 2)
+
+			img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			descriptors, err := img.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 3)
+			h.AssertEq(t, descriptors[2].DiffID, diffIDs[2])
+		})
+
+		it("writes only the selected layers", func() {
+			h.AssertNil(t, layout.Pull(imagePath, repoName, authn.DefaultKeychain, layout.LayersWithDiffIDs(diffIDs[0])))
+
+			//  expected blobs: manifest, config, first layer
+			h.AssertBlobsLen(t, imagePath, 3)
+
+			img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			descriptors, err := img.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertPathExists(t, filepath.Join(imagePath, "blobs", "sha256", strings.TrimPrefix(descriptors[0].Digest, "sha256:")))
+		})
+
+		it("annotates a digest reference with its digest", func() {
+			digestRepoName := strings.TrimSuffix(repoName, ":some-tag") + "@" + digest
+			h.AssertNil(t, layout.Pull(imagePath, digestRepoName, authn.DefaultKeychain, layout.NoLayers))
+
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 1)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, digest)
+		})
+
+		it("adds the image to an existing layout, replacing the image with the same ref name", func() {
+			otherImage, err := layout.NewImage(imagePath, layout.WithRepository("other-image"))
+			h.AssertNil(t, err)
+			h.AssertNil(t, otherImage.Save())
+			h.AssertNil(t, layout.Pull(imagePath, repoName, authn.DefaultKeychain, layout.NoLayers))
+			h.AssertNil(t, layout.Pull(imagePath, repoName, authn.DefaultKeychain, layout.NoLayers))
+
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 2)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "other-image")
+			h.AssertEqAnnotation(t, index.Manifests[1], layout.ImageRefNameKey, "some-tag")
+		})
+
+		when("the image does not exist", func() {
+			it("returns an error", func() {
+				missingRepoName := strings.TrimPrefix(server.URL, "http://") + "/missing-image"
+
+				err := layout.Pull(imagePath, missingRepoName, authn.DefaultKeychain, layout.AllLayers)
+				h.AssertError(t, err, "not found")
+				h.AssertEq(t, layout.ImageExists(imagePath), false)
+			})
+		})
+	})
+
+	when("#WithRepository", func() {
+		var (
+			imageA, imageB   *layout.Image
+			diffIDA, diffIDB string
+		)
+
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "repository")
+
+			imageA, err = layout.NewImage(imagePath, layout.WithRepository("image-a"))
+			h.AssertNil(t, err)
+			var layerPath string
+			layerPath, diffIDA, _ = h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, imageA.AddLayer(layerPath))
+			h.AssertNil(t, imageA.Save())
+
+			imageB, err = layout.NewImage(imagePath, layout.WithRepository("image-b"))
+			h.AssertNil(t, err)
+			layerPath, diffIDB, _ = h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, imageB.AddLayer(layerPath))
+			h.AssertNil(t, imageB.Save())
+		})
+
+		it("saves the images in the same layout", func() {
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 2)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "image-a")
+			h.AssertEqAnnotation(t, index.Manifests[1], layout.ImageRefNameKey, "image-b")
+			h.AssertEq(t, imageA.Found(), true)
+			h.AssertEq(t, imageB.Found(), true)
+		})
+
+		it("replaces the manifest with the same ref name", func() {
+			h.AssertNil(t, imageA.SetLabel("some-label", "some-value"))
+			h.AssertNil(t, imageA.Save())
+
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 2)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "image-b")
+			h.AssertEqAnnotation(t, index.Manifests[1], layout.ImageRefNameKey, "image-a")
+			identifier, err := imageA.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.HasSuffix(identifier.String(), index.Manifests[1].Digest.String()), true)
+		})
+
+		it("loads the base image with the ref name of the image from the repository", func() {
+			img, err := layout.NewImage(imagePath, layout.WithRepository("image-b"), layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffIDB)
+
+			img, err = layout.NewImage(imagePath, layout.WithRepository("image-c"), layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+			_, err = img.TopLayer()
+			h.AssertError(t, err, "has no layers")
+		})
+
+		it("loads the previous image with the ref name of the image from the repository", func() {
+			img, err := layout.NewImage(imagePath, layout.WithRepository("image-b"), layout.WithPreviousImage(imagePath))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.ReuseLayer(diffIDB))
+			h.AssertError(t, img.ReuseLayer(diffIDA), "did not have layer")
+		})
+
+		it("keeps a single manifest when the image is saved again unchanged", func() {
+			h.AssertNil(t, imageA.Save())
+
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 2)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "image-b")
+			h.AssertEqAnnotation(t, index.Manifests[1], layout.ImageRefNameKey, "image-a")
+		})
+
+		it("deletes only the manifest with the ref name of the image", func() {
+			h.AssertNil(t, imageA.Delete())
+
+			index := h.ReadIndexManifest(t, imagePath)
+			h.AssertEq(t, len(index.Manifests), 1)
+			h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "image-b")
+			h.AssertEq(t, imageA.Found(), false)
+			h.AssertEq(t, imageB.Found(), true)
+		})
+
+		when("#GarbageCollect", func() {
+			it("removes the blobs of the deleted images", func() {
+				descriptorsA, err := imageA.LayerDescriptors()
+				h.AssertNil(t, err)
+				descriptorsB, err := imageB.LayerDescriptors()
+				h.AssertNil(t, err)
+				h.AssertNil(t, imageA.Delete())
+
+				removed, err := layout.GarbageCollect(imagePath)
+				h.AssertNil(t, err)
+				// manifest, config, layer
+				h.AssertEq(t, len(removed), 3)
+				h.AssertBlobsLen(t, imagePath, 3)
+				blobsDir := filepath.Join(imagePath, "blobs", "sha256")
+				_, err = os.Stat(filepath.Join(blobsDir, strings.TrimPrefix(descriptorsA[0].Digest, "sha256:")))
+				h.AssertEq(t, os.IsNotExist(err), true)
+				h.AssertPathExists(t, filepath.Join(blobsDir, strings.TrimPrefix(descriptorsB[0].Digest, "sha256:")))
+
+				img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+				h.AssertNil(t, err)
+				topLayer, err := img.TopLayer()
+				h.AssertNil(t, err)
+				h.AssertEq(t, topLayer, diffIDB)
+				h.AssertNotEq(t, topLayer, diffIDA)
+			})
+
+			it("keeps the blobs referenced by the layout", func() {
+				removed, err := layout.GarbageCollect(imagePath)
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(removed), 0)
+				h.AssertBlobsLen(t, imagePath, 6)
+			})
+		})
+	})
+
+	when("#SaveFile", func() {
+		it.Before(func() {
+			imagePath = filepath.Join(tmpDir, "save-file")
+		})
+
+		it("saves the image as an OCI archive that can be loaded as a base image", func() {
+			image, err := layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+			layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, image.AddLayer(layerPath))
+			h.AssertNil(t, image.SetLabel("some-label", "some-value"))
+
+			archivePath, err := image.SaveFile()
+			h.AssertNil(t, err)
+			defer os.Remove(archivePath)
+
+			loaded, err := layout.NewImage(filepath.Join(tmpDir, "loaded"), layout.FromBaseImageArchive(archivePath))
+			h.AssertNil(t, err)
+			topLayer, err := loaded.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, diffID)
+			label, err := loaded.Label("some-label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			rc, err := loaded.GetLayer(diffID)
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+		})
+
+		it("writes the same archive for the same image", func() {
+			image, err := layout.NewImage(imagePath)
+			h.AssertNil(t, err)
+			layerPath, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, image.AddLayer(layerPath))
+
+			archivePath, err := image.SaveFile()
+			h.AssertNil(t, err)
+			defer os.Remove(archivePath)
+			otherArchivePath, err := image.SaveFile()
+			h.AssertNil(t, err)
+			defer os.Remove(otherArchivePath)
+
+			archive, err := os.ReadFile(archivePath)
+			h.AssertNil(t, err)
+			otherArchive, err := os.ReadFile(otherArchivePath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, archive, otherArchive)
+		})
+
+		when("the image is sparse", func() {
+			it("returns an error", func() {
+				image, err := layout.NewImage(imagePath, layout.FromBaseImagePath(sparseBaseImagePath))
+				h.AssertNil(t, err)
+
+				_, err = image.SaveFile()
+				h.AssertError(t, err, "is missing from image")
+			})
+		})
+	})
+
+	when("#Close", func() {
+		it("removes the archives written by SaveFile and the extracted base image archive", func() {
+			image, err := layout.NewImage(filepath.Join(tmpDir, "save-file"))
+			h.AssertNil(t, err)
+			archivePath, err := image.SaveFile()
+			h.AssertNil(t, err)
+			defer os.Remove(archivePath)
+
+			loaded, err := layout.NewImage(filepath.Join(tmpDir, "loaded"), layout.FromBaseImageArchive(archivePath))
+			h.AssertNil(t, err)
+			otherArchivePath, err := loaded.SaveFile()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, loaded.Close())
+			_, err = os.Stat(otherArchivePath)
+			h.AssertEq(t, os.IsNotExist(err), true)
+			_, err = os.Stat(archivePath)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, image.Close())
+			_, err = os.Stat(archivePath)
+			h.AssertEq(t, os.IsNotExist(err), true)
+		})
+	})
+
+	when("#Rebase", func() {
+		var (
+			oldBaseImage, newBaseImage, origImage *layout.Image
+			oldTopLayerDiffID                     string
+			newBaseLayers, origTopLayers          []v1.Layer
+		)
+
+		it.Before(func() {
+			// new base
+			newBaseImage, err = layout.NewImage(filepath.Join(tmpDir, "new-base"), layout.WithDefaultPlatform(imgutil.Platform{
+				OS:           "linux",
+				Architecture: "arm64",
+				OSVersion:    "new-base-os-version",
+				Variant:      "v8",
+			}))
+			h.AssertNil(t, err)
+			newBaseLayer1Path, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, newBaseImage.AddLayer(newBaseLayer1Path))
+			newBaseLayer2Path, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, newBaseImage.AddLayer(newBaseLayer2Path))
+			h.AssertNil(t, newBaseImage.Save())
+
+			newBaseLayers, err = newBaseImage.Layers()
+			h.AssertNil(t, err)
+
+			// old base
+			oldBasePath := filepath.Join(tmpDir, "old-base")
+			oldBaseImage, err = layout.NewImage(oldBasePath)
+			h.AssertNil(t, err)
+			oldBaseLayer1Path, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, oldBaseImage.AddLayer(oldBaseLayer1Path))
+			oldBaseLayer2Path, oldBaseLayer2DiffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, oldBaseImage.AddLayer(oldBaseLayer2Path))
+			h.AssertNil(t, oldBaseImage.Save())
+			oldTopLayerDiffID = oldBaseLayer2DiffID
+
+			// original image
+			imagePath = filepath.Join(tmpDir, "original")
+			origImage, err = layout.NewImage(imagePath, layout.FromBaseImagePath(oldBasePath))
+			h.AssertNil(t, err)
+			origLayer1Path, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, origImage.AddLayer(origLayer1Path))
+			origLayer2Path, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, origImage.AddLayer(origLayer2Path))
+			h.AssertNil(t, origImage.Save())
+
+			origLayers, err := origImage.Layers()
+			h.AssertNil(t, err)
+			origTopLayers = origLayers[2:]
+		})
+
+		it("switches the base", func() {
+			img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.Rebase(oldTopLayerDiffID, newBaseImage))
+			h.AssertNil(t, img.Save())
+
+			index := h.ReadIndexManifest(t, imagePath)
+			manifest := h.ReadManifest(t, index.Manifests[0].Digest, imagePath)
+			var expectedLayers []v1.Hash
+			for _, layer := range append(newBaseLayers, origTopLayers...) {
+				digest, err := layer.Digest()
+				h.AssertNil(t, err)
+				expectedLayers = append(expectedLayers, digest)
+			}
+			h.AssertEq(t, len(manifest.Layers), len(expectedLayers))
+			for idx, layer := range manifest.Layers {
+				h.AssertEq(t, layer.Digest, expectedLayers[idx])
+			}
+
+			configFile := h.ReadConfigFile(t, manifest, imagePath)
+			h.AssertEq(t, configFile.OS, "linux")
+			h.AssertEq(t, configFile.Architecture, "arm64")
+			h.AssertEq(t, configFile.OSVersion, "new-base-os-version")
+			h.AssertEq(t, configFile.Variant, "v8")
+		})
+
+		it("returns an error when the base top layer is not found", func() {
+			img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(imagePath))
+			h.AssertNil(t, err)
+
+			h.AssertError(t, img.Rebase("sha256:"+strings.Repeat("0", 64), newBaseImage), "could not find base layer in image")
+		})
+
+		when("#WithBaseImageAnnotations", func() {
+			it("records the base image and updates it with the new base", func() {
+				oldBasePath := filepath.Join(tmpDir, "old-base")
+				img, err := layout.NewImage(imagePath, layout.FromBaseImagePath(oldBasePath), layout.WithBaseImageAnnotations())
+				h.AssertNil(t, err)
+
+				annotations, err := img.Annotations()
+				h.AssertNil(t, err)
+				h.AssertEq(t, annotations[imgutil.BaseImageNameKey], oldBasePath)
+				h.AssertEq(t, annotations[imgutil.BaseImageDigestKey], h.ReadIndexManifest(t, oldBasePath).Manifests[0].Digest.String())
+
+				h.AssertNil(t, img.Rebase(oldTopLayerDiffID, newBaseImage))
+				h.AssertNil(t, img.Save())
+
+				newBasePath := filepath.Join(tmpDir, "new-base")
+				index := h.ReadIndexManifest(t, imagePath)
+				manifest := h.ReadManifest(t, index.Manifests[0].Digest, imagePath)
+				h.AssertEq(t, manifest.Annotations[imgutil.BaseImageNameKey], newBasePath)
+				h.AssertEq(t, manifest.Annotations[imgutil.BaseImageDigestKey], h.ReadIndexManifest(t, newBasePath).Manifests[0].Digest.String())
+			})
+
+			it("records only the digest of a base image without a path", func() {
+				img, err := layout.NewImage(imagePath, layout.FromBaseImage(newBaseImage), layout.WithBaseImageAnnotations())
+				h.AssertNil(t, err)
+
+				digest, err := newBaseImage.Digest()
+				h.AssertNil(t, err)
+				annotations, err := img.Annotations()
 				h.AssertNil(t, err)
+				h.AssertEq(t, annotations[imgutil.BaseImageDigestKey], digest.String())
+				_, ok := annotations[imgutil.BaseImageNameKey]
+				h.AssertEq(t, ok, false)
 			})
 		})
 	})
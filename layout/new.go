@@ -1,7 +1,8 @@
 package layout
 
 import (
-	"fmt"
+	"os"
+	"path/filepath"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
@@ -31,8 +32,21 @@ func NewImage(path string, ops ...ImageOption) (*Image, error) {
 	}
 
 	ri := &Image{
-		Image: image,
-		path:  path,
+		Image:             image,
+		path:              path,
+		annotateBaseImage: imageOpts.baseImageAnnotations,
+		progress:          imageOpts.progress,
+		logger:            imageOpts.logger,
+		layerSource:       imageOpts.layerSource,
+		withHistory:       imageOpts.history,
+		annotations:       map[string]string{},
+	}
+	if imageOpts.repositoryRefName != "" {
+		ri.repository = true
+		ri.refName = imageOpts.repositoryRefName
+	}
+	if ri.logger == nil {
+		ri.logger = imgutil.NopLogger{}
 	}
 
 	if imageOpts.prevImagePath != "" {
@@ -41,12 +55,21 @@ func NewImage(path string, ops ...ImageOption) (*Image, error) {
 		}
 	}
 
+	if imageOpts.baseImageArchive != "" {
+		baseImagePath, err := extractBaseImageArchive(imageOpts.baseImageArchive)
+		if err != nil {
+			return nil, err
+		}
+		imageOpts.baseImagePath = baseImagePath
+		ri.tmpPaths = append(ri.tmpPaths, baseImagePath)
+	}
+
 	if imageOpts.baseImagePath != "" {
 		if err := processBaseImagePathOption(ri, imageOpts.baseImagePath, platform); err != nil {
 			return nil, err
 		}
 	} else if imageOpts.baseImage != nil {
-		if err := ri.setUnderlyingImage(imageOpts.baseImage); err != nil {
+		if err := processBaseImageOption(ri, imageOpts.baseImage); err != nil {
 			return nil, err
 		}
 	}
@@ -81,6 +104,7 @@ func emptyImage(platform imgutil.Platform) (v1.Image, error) {
 		Architecture: platform.Architecture,
 		OS:           platform.OS,
 		OSVersion:    platform.OSVersion,
+		Variant:      platform.Variant,
 		RootFS: v1.RootFS{
 			Type:    "layers",
 			DiffIDs: []v1.Hash{},
@@ -92,7 +116,7 @@ func emptyImage(platform imgutil.Platform) (v1.Image, error) {
 }
 
 func processPreviousImageOption(ri *Image, prevImagePath string, platform imgutil.Platform) error {
-	prevImage, err := newV1Image(prevImagePath, platform)
+	prevImage, err := newV1Image(prevImagePath, platform, ri.repositoryRefName(prevImagePath))
 	if err != nil {
 		return err
 	}
@@ -108,26 +132,33 @@ func processPreviousImageOption(ri *Image, prevImagePath string, platform imguti
 }
 
 // newV1Image creates a layout image from the given path.
+//   - If a ref name is provided, as for the layout repository of the image, the image is the manifest annotated with it
 //   - If a ImageIndex for multiples platforms exists, then it will try to select the image
 //     according to the platform provided
 //   - If the image does not exist, then an empty image is returned
-func newV1Image(path string, platform imgutil.Platform) (v1.Image, error) {
+func newV1Image(path string, platform imgutil.Platform, refName string) (v1.Image, error) {
 	var (
 		image  v1.Image
 		layout Path
 		err    error
 	)
 
-	if ImageExists(path) {
+	if imageExists(path, refName) {
 		layout, err = FromPath(path)
 		if err != nil {
 			return nil, errors.Wrap(err, "loading layout from path new")
 		}
 
-		index, err := layout.ImageIndex()
+		var index v1.ImageIndex
+		index, err = layout.ImageIndex()
 		if err != nil {
 			return nil, errors.Wrap(err, "reading index")
 		}
+		if refName != "" {
+			index = mutate.RemoveManifests(index, func(desc v1.Descriptor) bool {
+				return desc.Annotations[ImageRefNameKey] != refName
+			})
+		}
 
 		image, err = imageFromIndex(index, platform)
 		if err != nil {
@@ -145,8 +176,29 @@ func newV1Image(path string, platform imgutil.Platform) (v1.Image, error) {
 	}, nil
 }
 
-// imageFromIndex creates a v1.Image from the given Image Index, selecting the image manifest
-// that matches the given OS and architecture.
+// imageExists tells whether the layout at the provided path has an image, or a manifest with the ref name if provided
+func imageExists(path, refName string) bool {
+	if refName != "" {
+		return repositoryImageExists(path, refName)
+	}
+	return ImageExists(path)
+}
+
+// repositoryRefName returns the ref name of an image saved in a layout repository, see WithRepository, when the provided
+// path is its repository or another layout with a manifest annotated with that ref name, so that the image is loaded
+// by its ref name rather than by its platform.
+func (i *Image) repositoryRefName(path string) string {
+	if !i.repository {
+		return ""
+	}
+	if filepath.Clean(path) == filepath.Clean(i.path) || repositoryImageExists(path, i.refName) {
+		return i.refName
+	}
+	return ""
+}
+
+// imageFromIndex creates a v1.Image from the given Image Index: the image of an index with a single image manifest,
+// or the image manifest, possibly in a nested index, matching the given platform.
 func imageFromIndex(index v1.ImageIndex, platform imgutil.Platform) (v1.Image, error) {
 	indexManifest, err := index.IndexManifest()
 	if err != nil {
@@ -157,32 +209,48 @@ func imageFromIndex(index v1.ImageIndex, platform imgutil.Platform) (v1.Image, e
 		return nil, errors.New("no underlyingImage indexManifest found")
 	}
 
-	manifest := indexManifest.Manifests[0]
-	if len(indexManifest.Manifests) > 1 {
-		// Find based on platform (os/arch)
-		for _, m := range indexManifest.Manifests {
-			if m.Platform.OS == platform.OS && m.Platform.Architecture == platform.OS {
-				manifest = m
-				break
-			}
+	if len(indexManifest.Manifests) == 1 && indexManifest.Manifests[0].MediaType.IsImage() {
+		return index.Image(indexManifest.Manifests[0].Digest)
+	}
+	return imgutil.ImageFromIndex(index, imgutil.V1Platform(platform))
+}
+
+func processBaseImageOption(ri *Image, baseImage v1.Image) error {
+	if ri.annotateBaseImage {
+		if err := imgutil.AnnotateBaseImage(ri.annotations, baseImage, ""); err != nil {
+			return errors.Wrap(err, "annotating base image")
 		}
-		return nil, fmt.Errorf("manifest matching platform %v not found", platform)
 	}
 
-	image, err := index.Image(manifest.Digest)
+	return ri.setUnderlyingImage(baseImage)
+}
+
+// extractBaseImageArchive extracts the OCI archive at the provided path to a temporary directory and returns its path
+func extractBaseImageArchive(archivePath string) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "imgutil.layout.import.")
 	if err != nil {
-		return nil, err
+		return "", errors.Wrap(err, "failed to create temp dir")
 	}
-
-	return image, nil
+	if err := ExtractArchive(archivePath, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return tmpDir, nil
 }
 
 func processBaseImagePathOption(ri *Image, baseImagePath string, platform imgutil.Platform) error {
-	baseImage, err := newV1Image(baseImagePath, platform)
+	refName := ri.repositoryRefName(baseImagePath)
+	baseImage, err := newV1Image(baseImagePath, platform, refName)
 	if err != nil {
 		return err
 	}
 
+	if ri.annotateBaseImage && imageExists(baseImagePath, refName) {
+		if err := imgutil.AnnotateBaseImage(ri.annotations, baseImage, baseImagePath); err != nil {
+			return errors.Wrapf(err, "annotating base image at path %q", baseImagePath)
+		}
+	}
+
 	return ri.setUnderlyingImage(baseImage)
 }
 
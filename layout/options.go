@@ -3,20 +3,31 @@ package layout
 import (
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
 )
 
 type ImageOption func(*options) error
 
 type options struct {
-	platform      imgutil.Platform
-	baseImage     v1.Image
-	baseImagePath string
-	prevImagePath string
-	createdAt     time.Time
-	mediaTypes    imgutil.MediaTypes
+	platform         imgutil.Platform
+	baseImage        v1.Image
+	baseImagePath    string
+	baseImageArchive string
+	prevImagePath    string
+	createdAt        time.Time
+	mediaTypes       imgutil.MediaTypes
+
+	baseImageAnnotations bool
+	progress             imgutil.ProgressFunc
+	logger               imgutil.Logger
+	layerSource          *layerSource
+	repositoryRefName    string
+	history              bool
 }
 
 // FromBaseImage loads the given image as the config and layers for the new image.
@@ -37,6 +48,26 @@ func FromBaseImagePath(path string) ImageOption {
 	}
 }
 
+// FromBaseImageArchive loads the image of an OCI archive (oci-archive), e.g. written by SaveFile, skopeo or buildah,
+// as the config and layers for the new image. The archive is extracted to a temporary directory,
+// which is removed by Close as the image reads its layers from there.
+func FromBaseImageArchive(path string) ImageOption {
+	return func(i *options) error {
+		i.baseImageArchive = path
+		return nil
+	}
+}
+
+// WithBaseImageAnnotations records the manifest digest and, when loaded with FromBaseImagePath, the path of the base image
+// as the org.opencontainers.image.base.digest and org.opencontainers.image.base.name manifest annotations,
+// and updates them with those of the new base when the image is rebased.
+func WithBaseImageAnnotations() ImageOption {
+	return func(i *options) error {
+		i.baseImageAnnotations = true
+		return nil
+	}
+}
+
 // WithCreatedAt lets a caller set the created at timestamp for the image.
 // Defaults for a new image is imgutil.NormalizedDateTime
 func WithCreatedAt(createdAt time.Time) ImageOption {
@@ -56,6 +87,39 @@ func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
 	}
 }
 
+// WithHistory keeps the history of the image when it is saved, i.e. the history of the base image
+// and the entries provided with AddLayerWithDiffIDAndHistory and ReuseLayerWithHistory,
+// with the creation time of every entry set to the created at timestamp of the image.
+// Without it, the history is saved as an empty entry for each layer.
+func WithHistory() ImageOption {
+	return func(i *options) error {
+		i.history = true
+		return nil
+	}
+}
+
+// WithLayerSource backs a sparse image with the image with the provided reference in a registry:
+// the layers missing from the layout are fetched by digest from the repository of the reference when they are read
+// with GetLayer, and their contents are verified against the digests in the manifest. When writeBack is true,
+// the fetched layers are also written to the `blobs` directory of the image path, so they are only fetched once.
+// Without a layer source, GetLayer returns an error for layers missing from the layout.
+// Options provide e.g. the context and the registry settings used to fetch the layers, see remote.NewV1Layer.
+func WithLayerSource(ref string, keychain authn.Keychain, writeBack bool, ops ...remote.V1ImageOption) ImageOption {
+	return func(i *options) error {
+		i.layerSource = &layerSource{ref: ref, keychain: keychain, writeBack: writeBack, ops: ops}
+		return nil
+	}
+}
+
+// WithLogger lets a caller receive diagnostic messages about the operations of the image,
+// such as blobs skipped when saving. Defaults to imgutil.NopLogger.
+func WithLogger(logger imgutil.Logger) ImageOption {
+	return func(i *options) error {
+		i.logger = logger
+		return nil
+	}
+}
+
 // WithMediaTypes lets a caller set the desired media types for the image manifest and config files,
 // including the layers referenced in the manifest, to be either OCI media types or Docker media types.
 func WithMediaTypes(requested imgutil.MediaTypes) ImageOption {
@@ -65,6 +129,29 @@ func WithMediaTypes(requested imgutil.MediaTypes) ImageOption {
 	}
 }
 
+// WithProgress lets a caller receive the progress of the layers written to the layout when the image is saved.
+func WithProgress(fn imgutil.ProgressFunc) ImageOption {
+	return func(i *options) error {
+		i.progress = fn
+		return nil
+	}
+}
+
+// WithRepository saves the image in a layout shared with other images, as the manifest annotated with the provided
+// ref name (org.opencontainers.image.ref.name): Save adds the image to the index.json of the layout, replacing the
+// manifest with the same ref name, Delete removes only that manifest and Found looks for it. FromBaseImagePath and
+// WithPreviousImage load the manifest with the ref name from the repository, rather than the manifest of the platform.
+// Blobs are shared by the images of the layout and are not removed by Delete, see GarbageCollect.
+func WithRepository(refName string) ImageOption {
+	return func(i *options) error {
+		if refName == "" {
+			return errors.New("a ref name is required to save the image in a layout repository")
+		}
+		i.repositoryRefName = refName
+		return nil
+	}
+}
+
 // WithPreviousImage loads an existing image as a source for reusable layers.
 // Use with ReuseLayer().
 // Ignored if underlyingImage is not found.
@@ -74,3 +161,28 @@ func WithPreviousImage(path string) ImageOption {
 		return nil
 	}
 }
+
+type IndexOption func(*indexOptions) error
+
+type indexOptions struct {
+	baseIndexPath string
+	mediaTypes    imgutil.MediaTypes
+}
+
+// FromBaseIndexPath loads an existing index as the manifests and annotations for the new index.
+// Ignored if index is not found.
+func FromBaseIndexPath(path string) IndexOption {
+	return func(i *indexOptions) error {
+		i.baseIndexPath = path
+		return nil
+	}
+}
+
+// WithIndexMediaTypes lets a caller set the desired media type for the index manifest,
+// to be either an OCI image index or a Docker manifest list.
+func WithIndexMediaTypes(requested imgutil.MediaTypes) IndexOption {
+	return func(i *indexOptions) error {
+		i.mediaTypes = requested
+		return nil
+	}
+}
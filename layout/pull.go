@@ -0,0 +1,80 @@
+package layout
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+)
+
+// LayerFilter selects the layers of an image to write in a layout, see Pull and WithLayerFilter.
+type LayerFilter func(layer imgutil.LayerDescriptor) bool
+
+// AllLayers selects every layer, the image written in the layout is complete.
+func AllLayers(imgutil.LayerDescriptor) bool {
+	return true
+}
+
+// NoLayers selects no layer, the image written in the layout is sparse.
+func NoLayers(imgutil.LayerDescriptor) bool {
+	return false
+}
+
+// LayersWithDiffIDs selects the layers with the provided diff IDs, e.g. the layers of the base image,
+// which are needed to rebase or restore an image.
+func LayersWithDiffIDs(diffIDs ...string) LayerFilter {
+	selected := make(map[string]bool, len(diffIDs))
+	for _, diffID := range diffIDs {
+		selected[diffID] = true
+	}
+	return func(layer imgutil.LayerDescriptor) bool {
+		return selected[layer.DiffID]
+	}
+}
+
+// Pull writes the image with the provided reference from a registry to a layout at the provided path,
+// with only the layers selected by the provided filter in the `blobs` directory. The image manifest and config
+// are always written, so the layout can be read like any sparse image. Options select e.g. the platform
+// of a multi-platform image or the registry settings. Pull returns an error if the image is not found.
+// The manifest is annotated with the tag or the digest of the reference as its ref name. When a layout already exists
+// at the path, the image is added to it, replacing the manifest with the same ref name.
+func Pull(path, ref string, keychain authn.Keychain, filter LayerFilter, ops ...remote.V1ImageOption) error {
+	image, err := remote.NewV1Image(ref, keychain, append(ops, remote.WithV1MustExist())...)
+	if err != nil {
+		return errors.Wrapf(err, "pulling image %q", ref)
+	}
+
+	var refName string
+	if tag, err := name.NewTag(ref, name.WeakValidation); err == nil {
+		refName = tag.TagStr()
+	} else if digest, err := name.NewDigest(ref, name.WeakValidation); err == nil {
+		refName = digest.DigestStr()
+	}
+
+	var layoutPath Path
+	if ImageExists(path) {
+		layoutPath, err = FromPath(path)
+	} else {
+		layoutPath, err = Write(path, empty.Index)
+	}
+	if err != nil {
+		return err
+	}
+	if err := layoutPath.AppendImage(image, WithLayerFilter(filter), WithAnnotations(ImageRefAnnotation(refName))); err != nil {
+		return errors.Wrapf(err, "writing image %q to layout %q", ref, path)
+	}
+	if refName == "" {
+		return nil
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	if err := layoutPath.removeReplacedDescriptors(refName, digest); err != nil {
+		return errors.Wrapf(err, "replacing image %q in layout %q", refName, path)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package layout
+
+import (
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+// repositoryImageExists tells whether the layout at the provided path has a manifest with the provided ref name
+func repositoryImageExists(path, refName string) bool {
+	if !ImageExists(path) {
+		return false
+	}
+	layoutPath, err := FromPath(path)
+	if err != nil {
+		return false
+	}
+	index, err := layoutPath.ImageIndex()
+	if err != nil {
+		return false
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return false
+	}
+	for _, desc := range indexManifest.Manifests {
+		if desc.Annotations[ImageRefNameKey] == refName {
+			return true
+		}
+	}
+	return false
+}
+
+// removeReplacedDescriptors removes the descriptors with the provided ref name from the index of the layout, except the
+// last one with the provided digest, i.e. the descriptor of the image appended to replace them. Removing the descriptors
+// once the image is appended keeps the previous image in the layout when the image cannot be written.
+func (l Path) removeReplacedDescriptors(refName string, digest v1.Hash) error {
+	index, err := l.ImageIndex()
+	if err != nil {
+		return err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	replacing := 0
+	for _, desc := range indexManifest.Manifests {
+		if desc.Annotations[ImageRefNameKey] == refName && desc.Digest == digest {
+			replacing++
+		}
+	}
+	return l.RemoveDescriptors(func(desc v1.Descriptor) bool {
+		if desc.Annotations[ImageRefNameKey] != refName {
+			return false
+		}
+		if desc.Digest != digest {
+			return true
+		}
+		replacing--
+		return replacing > 0
+	})
+}
+
+// GarbageCollect removes the blobs of the layout at the provided path that are not referenced by its index.json,
+// e.g. the blobs of the images deleted from a layout repository, see WithRepository.
+// Blobs are referenced by the manifests of the index, including nested indexes, and by the config and layers of the
+// image manifests. It returns the digests of the removed blobs.
+func GarbageCollect(path string) ([]v1.Hash, error) {
+	layoutPath, err := FromPath(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading layout at path %q", path)
+	}
+	index, err := layoutPath.ImageIndex()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading index at path %q", path)
+	}
+	referenced := map[v1.Hash]bool{}
+	if err := addReferencedBlobs(index, referenced); err != nil {
+		return nil, errors.Wrapf(err, "finding blobs referenced by layout at path %q", path)
+	}
+
+	var removed []v1.Hash
+	algorithms, err := os.ReadDir(layoutPath.append("blobs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		blobs, err := os.ReadDir(layoutPath.append("blobs", algorithm.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range blobs {
+			hash, err := v1.NewHash(algorithm.Name() + ":" + blob.Name())
+			if err != nil || blob.IsDir() {
+				continue // not a blob, e.g. a temporary file of a blob being written
+			}
+			if referenced[hash] {
+				continue
+			}
+			if err := os.Remove(layoutPath.append("blobs", algorithm.Name(), blob.Name())); err != nil {
+				return nil, errors.Wrapf(err, "removing blob %q", hash)
+			}
+			removed = append(removed, hash)
+		}
+	}
+	return removed, nil
+}
+
+// addReferencedBlobs adds the digests of the blobs referenced by the provided index to referenced
+func addReferencedBlobs(index v1.ImageIndex, referenced map[v1.Hash]bool) error {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range indexManifest.Manifests {
+		referenced[desc.Digest] = true
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := addReferencedBlobs(child, referenced); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			image, err := index.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			manifest, err := image.Manifest()
+			if err != nil {
+				return errors.Wrapf(err, "reading manifest %q", desc.Digest)
+			}
+			referenced[manifest.Config.Digest] = true
+			for _, layer := range manifest.Layers {
+				referenced[layer.Digest] = true
+			}
+		}
+	}
+	return nil
+}
@@ -15,6 +15,37 @@ func (i *Image) Save(additionalNames ...string) error {
 
 // SaveAs ignores the image `Name()` method and saves the image according to name & additional names provided to this method
 func (i *Image) SaveAs(name string, additionalNames ...string) error {
+	if err := i.PrepareToSave(); err != nil {
+		return err
+	}
+
+	var diagnostics []imgutil.SaveDiagnostic
+	annotations := ImageRefAnnotation(i.refName)
+	pathsToSave := append([]string{name}, additionalNames...)
+	for _, path := range pathsToSave {
+		// initialize image path
+		path, err := i.layoutToSave(path)
+		if err != nil {
+			return err
+		}
+
+		if err := i.appendToLayout(path, annotations); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: i.Name(), Cause: err})
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
+
+// PrepareToSave sets the creation time of the image, records a history entry for each layer, normalized with WithHistory
+// and empty otherwise, clears the docker version and container of the config, and sets the manifest annotations.
+// SaveAs calls it before writing the image, as do the backends embedding a layout image to write it elsewhere,
+// e.g. archive.Image.
+func (i *Image) PrepareToSave() error {
 	err := i.mutateCreatedAt(i.Image, v1.Time{Time: i.createdAt})
 	if err != nil {
 		return errors.Wrap(err, "set creation time")
@@ -30,10 +61,14 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 	if err != nil {
 		return errors.Wrap(err, "get image layers")
 	}
-	cfg.History = make([]v1.History, len(layers))
-	for j := range cfg.History {
-		cfg.History[j] = v1.History{
-			Created: v1.Time{Time: i.createdAt},
+	if i.withHistory {
+		cfg.History = imgutil.NormalizedHistory(imgutil.LayersHistory(cfg.History, len(layers)), i.createdAt)
+	} else {
+		cfg.History = make([]v1.History, len(layers))
+		for j := range cfg.History {
+			cfg.History[j] = v1.History{
+				Created: v1.Time{Time: i.createdAt},
+			}
 		}
 	}
 
@@ -44,26 +79,42 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 		return errors.Wrap(err, "zeroing history")
 	}
 
-	var diagnostics []imgutil.SaveDiagnostic
-	annotations := ImageRefAnnotation(i.refName)
-	pathsToSave := append([]string{name}, additionalNames...)
-	for _, path := range pathsToSave {
-		// initialize image path
-		path, err := Write(path, empty.Index)
-		if err != nil {
-			return err
-		}
-
-		err = path.AppendImage(i.Image, WithAnnotations(annotations))
-		if err != nil {
-			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: i.Name(), Cause: err})
-		}
+	err = i.setUnderlyingImage(imgutil.WithManifestAnnotations(i.Image, i.annotations))
+	if err != nil {
+		return errors.Wrap(err, "set manifest annotations")
 	}
+	return nil
+}
 
-	if len(diagnostics) > 0 {
-		return imgutil.SaveError{Errors: diagnostics}
+// layoutToSave returns the layout at the provided path to save the image in: a new layout, or in a layout repository
+// the existing layout, where the saved image replaces the manifest with the ref name of the image, see appendToLayout.
+func (i *Image) layoutToSave(path string) (Path, error) {
+	if !i.repository || !ImageExists(path) {
+		return Write(path, empty.Index)
+	}
+	layoutPath, err := FromPath(path)
+	if err != nil {
+		return Path{}, errors.Wrapf(err, "loading layout repository at path %q", path)
 	}
+	return layoutPath, nil
+}
 
+// appendToLayout appends the image to the provided layout, and in a layout repository removes the manifests previously
+// saved with the ref name of the image once the image is written.
+func (i *Image) appendToLayout(path Path, annotations map[string]string) error {
+	if err := path.AppendImage(i.Image, WithAnnotations(annotations), withProgress(i.progress), withLogger(i.logger)); err != nil {
+		return err
+	}
+	if !i.repository {
+		return nil
+	}
+	digest, err := i.Image.Digest()
+	if err != nil {
+		return err
+	}
+	if err := path.removeReplacedDescriptors(i.refName, digest); err != nil {
+		return errors.Wrapf(err, "replacing image %q in layout repository at path %q", i.refName, path.Path)
+	}
 	return nil
 }
 
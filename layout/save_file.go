@@ -1,8 +1,56 @@
 package layout
 
-import "errors"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/pkg/errors"
+)
+
+// SaveFile saves the image as an OCI archive (oci-archive), see WriteArchive, and provides the filesystem location.
+// The archive must contain every layer of the image, so layers missing from a sparse image are fetched from the layer
+// source, see WithLayerSource, or result in an error without one.
+// The archive is removed by Close.
 func (i *Image) SaveFile() (string, error) {
-	// TODO issue https://github.com/buildpacks/imgutil/issues/170
-	return "", errors.New("not yet implemented")
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return "", errors.Wrap(err, "getting image layers")
+	}
+	var missingLayers []*notExistsLayer
+	for _, layer := range layers {
+		if missing, ok := layer.(*notExistsLayer); ok {
+			if i.layerSource == nil {
+				return "", fmt.Errorf("layer %q is missing from image at path %q and no layer source is configured", missing.diffID, i.path)
+			}
+			missingLayers = append(missingLayers, missing)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "imgutil.layout.export.")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+	layoutPath := filepath.Join(tmpDir, "layout")
+	if err := i.SaveAs(layoutPath); err != nil {
+		return "", err
+	}
+	for _, missing := range missingLayers {
+		if _, err := i.layerSource.writeLayer(missing, layoutPath); err != nil {
+			return "", err
+		}
+	}
+
+	f, err := os.CreateTemp("", "imgutil.layout.image.export.*.tar")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temporary file")
+	}
+	f.Close()
+	if err := WriteArchive(layoutPath, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	i.tmpPaths = append(i.tmpPaths, f.Name())
+	return f.Name(), nil
 }
@@ -0,0 +1,88 @@
+package layout
+
+import (
+	"io"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcr "github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+)
+
+// layerSource fetches the layers missing from a sparse image from a repository in a registry, see WithLayerSource.
+type layerSource struct {
+	keychain  authn.Keychain
+	ref       string
+	writeBack bool
+	ops       []remote.V1ImageOption
+}
+
+// uncompressed returns the uncompressed contents of the provided layer missing from the layout at the provided path,
+// writing the fetched layer to the layout first when writeBack is set.
+func (s *layerSource) uncompressed(layer v1.Layer, path string) (io.ReadCloser, error) {
+	if !s.writeBack {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+		remoteLayer, err := s.fetch(digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetching layer %q from %q", digest, s.ref)
+		}
+		// remote layers are verified against their digest when read
+		return remoteLayer.Uncompressed()
+	}
+
+	blobPath, err := s.writeLayer(layer, path)
+	if err != nil {
+		return nil, err
+	}
+	localLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return os.Open(blobPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return localLayer.Uncompressed()
+}
+
+// writeLayer fetches the provided layer and writes it to the `blobs` directory of the layout at the provided path,
+// and returns the path of the blob.
+func (s *layerSource) writeLayer(layer v1.Layer, path string) (string, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return "", err
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return "", err
+	}
+	remoteLayer, err := s.fetch(digest)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching layer %q from %q", digest, s.ref)
+	}
+	rc, err := remoteLayer.Compressed()
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching layer %q from %q", digest, s.ref)
+	}
+	layoutPath := Path{Path: ggcr.Path(path)}
+	// the blob is written to a temporary file, removed if the fetched contents do not match the digest
+	if err := layoutPath.writeBlob(digest, size, rc, layer.Digest, nil, imgutil.NopLogger{}); err != nil {
+		return "", errors.Wrapf(err, "writing layer %q to layout %q", digest, path)
+	}
+	return layoutPath.append("blobs", digest.Algorithm, digest.Hex), nil
+}
+
+// fetch returns the layer with the provided digest from the repository of the source reference
+func (s *layerSource) fetch(digest v1.Hash) (v1.Layer, error) {
+	keychain := s.keychain
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	return remote.NewV1Layer(s.ref, keychain, digest, s.ops...)
+}
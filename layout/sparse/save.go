@@ -17,6 +17,12 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 	refName, _ := i.Image.GetAnnotateRefName()
 	annotations := layout.ImageRefAnnotation(refName)
 
+	manifestAnnotations, err := i.Image.Annotations()
+	if err != nil {
+		return err
+	}
+	image := imgutil.WithManifestAnnotations(i, manifestAnnotations)
+
 	pathsToSave := append([]string{name}, additionalNames...)
 	for _, path := range pathsToSave {
 		layoutPath, err := layout.Write(path, empty.Index)
@@ -24,7 +30,7 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 			return err
 		}
 
-		err = layoutPath.AppendImage(i, layout.WithoutLayers(), layout.WithAnnotations(annotations))
+		err = layoutPath.AppendImage(image, layout.WithoutLayers(), layout.WithAnnotations(annotations))
 		if err != nil {
 			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: name, Cause: err})
 		}
@@ -107,5 +107,26 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEqAnnotation(t, index.Manifests[0], layout.ImageRefNameKey, "my-tag")
 			})
 		})
+
+		when("#SetAnnotation", func() {
+			it("creates an image and save it with the manifest annotations", func() {
+				image, err := sparse.NewImage(imagePath, testImage)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, image.SetAnnotation("org.opencontainers.image.source", "https://example.com/some-repo"))
+
+				// save
+				err = image.Save()
+				h.AssertNil(t, err)
+
+				// expected blobs: manifest, config
+				h.AssertBlobsLen(t, imagePath, 2)
+
+				index := h.ReadIndexManifest(t, imagePath)
+				h.AssertEq(t, len(index.Manifests), 1)
+				manifest := h.ReadManifest(t, index.Manifests[0].Digest, imagePath)
+				h.AssertEq(t, manifest.Annotations["org.opencontainers.image.source"], "https://example.com/some-repo")
+			})
+		})
 	})
 }
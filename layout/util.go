@@ -8,9 +8,11 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
 )
 
-const ImageRefNameKey = "org.opencontainers.image.ref.name"
+const ImageRefNameKey = imgutil.ImageRefNameKey
 
 // ParseRefToPath parse the given image reference to local path directory following the rules:
 // An image reference refers to either a tag reference or digest reference.
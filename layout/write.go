@@ -9,19 +9,23 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/google/go-containerregistry/pkg/logs"
 	"github.com/google/go-containerregistry/pkg/v1/stream"
 	"golang.org/x/sync/errgroup"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+
+	"github.com/buildpacks/imgutil"
 )
 
 type AppendOption func(*appendOptions)
 
 type appendOptions struct {
-	withoutLayers bool
 	annotations   map[string]string
+	progress      imgutil.ProgressFunc
+	logger        imgutil.Logger
+	layerFilter   LayerFilter
+	withoutLayers bool
 }
 
 func WithoutLayers() AppendOption {
@@ -30,19 +34,41 @@ func WithoutLayers() AppendOption {
 	}
 }
 
+// WithLayerFilter writes only the layers selected by the provided filter in the `blobs` directory, the other layers
+// are left out as with WithoutLayers().
+func WithLayerFilter(filter LayerFilter) AppendOption {
+	return func(i *appendOptions) {
+		i.layerFilter = filter
+	}
+}
+
 func WithAnnotations(annotations map[string]string) AppendOption {
 	return func(i *appendOptions) {
 		i.annotations = annotations
 	}
 }
 
+// withLogger reports the blobs skipped when appending an image
+func withLogger(logger imgutil.Logger) AppendOption {
+	return func(i *appendOptions) {
+		i.logger = logger
+	}
+}
+
+// withProgress reports the progress of the layers written when appending an image
+func withProgress(fn imgutil.ProgressFunc) AppendOption {
+	return func(i *appendOptions) {
+		i.progress = fn
+	}
+}
+
 // AppendImage mimics GGCR's AppendImage in that it appends an image to a `layout.Path`,
 // but the image appended does not include any layers in the `blobs` directory.
 // The returned image will return layers when Layers(), LayerByDiffID(), or LayerByDigest() are called,
 // but the returned layer will error when DiffID(), Compressed(), or Uncompressed() are called.
 // This is useful when we need to satisfy the v1.Image interface but do not need to access any layers.
 func (l Path) AppendImage(img v1.Image, ops ...AppendOption) error {
-	o := &appendOptions{}
+	o := &appendOptions{logger: imgutil.NopLogger{}}
 	for _, op := range ops {
 		op(o)
 	}
@@ -54,7 +80,7 @@ func (l Path) AppendImage(img v1.Image, ops ...AppendOption) error {
 	if o.withoutLayers {
 		return l.writeImageWithoutLayers(img, annotations)
 	}
-	return l.appendImage(img, annotations)
+	return l.appendImage(img, annotations, o.layerFilter, o.progress, o.logger)
 }
 
 // writeImageWithoutLayers is the same implementation of ggcr layout writeImage method, removing the writeLayer code
@@ -87,18 +113,40 @@ func (l Path) writeImageWithoutLayers(img v1.Image, annotations map[string]strin
 	return l.AppendDescriptor(desc)
 }
 
-func (l Path) appendImage(img v1.Image, annotations map[string]string) error {
+func (l Path) appendImage(img v1.Image, annotations map[string]string, filter LayerFilter, progressFn imgutil.ProgressFunc, logger imgutil.Logger) error {
 	layers, err := img.Layers()
 	if err != nil {
 		return err
 	}
+	if filter != nil {
+		if layers, err = filterLayers(img, layers, filter); err != nil {
+			return err
+		}
+	}
+
+	var progress *imgutil.ProgressTracker
+	if progressFn != nil {
+		var total int64
+		for _, layer := range layers {
+			if _, ok := layer.(*notExistsLayer); ok {
+				continue
+			}
+			if size, err := layer.Size(); err == nil {
+				total += size
+			}
+		}
+		progress = imgutil.NewProgressTracker(progressFn, total)
+	}
 
 	// Write the layers concurrently.
 	var g errgroup.Group
 	for _, layer := range layers {
 		layer := layer
+		if _, ok := layer.(*notExistsLayer); ok {
+			continue // nothing is written for layers without data
+		}
 		g.Go(func() error {
-			return l.writeLayer(layer)
+			return l.writeLayer(layer, progress, logger)
 		})
 	}
 	if err := g.Wait(); err != nil {
@@ -108,6 +156,27 @@ func (l Path) appendImage(img v1.Image, annotations map[string]string) error {
 	return l.writeImageWithoutLayers(img, annotations)
 }
 
+// filterLayers returns the layers selected by the provided filter, the other layers are replaced by layers without data
+func filterLayers(img v1.Image, layers []v1.Layer, filter LayerFilter) ([]v1.Layer, error) {
+	descriptors, err := imgutil.LayerDescriptors(img)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]v1.Layer, len(layers))
+	for idx, layer := range layers {
+		if _, ok := layer.(*notExistsLayer); ok || filter(descriptors[idx]) {
+			filtered[idx] = layer
+			continue
+		}
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+		filtered[idx] = &notExistsLayer{Layer: layer, diffID: diffID}
+	}
+	return filtered, nil
+}
+
 func (l Path) writeImage(img v1.Image) error {
 	// Write the config.
 	cfgName, err := img.ConfigName()
@@ -155,7 +224,7 @@ func FromPath(path string) (Path, error) {
 
 // writeLayer is the same internal implementation from ggcr layout package, but because it is calling an internal
 // writeBlob method we need to override we copied here.
-func (l Path) writeLayer(layer v1.Layer) error {
+func (l Path) writeLayer(layer v1.Layer, progress *imgutil.ProgressTracker, logger imgutil.Logger) error {
 	d, err := layer.Digest()
 
 	if errors.Is(err, stream.ErrNotComputed) {
@@ -187,15 +256,16 @@ func (l Path) writeLayer(layer v1.Layer) error {
 		return err
 	}
 
-	if err := l.writeBlob(d, s, r, layer.Digest); err != nil {
+	if err := l.writeBlob(d, s, r, layer.Digest, progress, logger); err != nil {
 		return fmt.Errorf("error writing layer: %w", err)
 	}
 	return nil
 }
 
-// writeBlob ggcr implementation was modified to skip the blob when it returns a size of zero.
+// writeBlob ggcr implementation was modified to skip the blob when it returns a size of zero,
+// and to report the progress of the blob to the provided tracker and the skipped blobs to the provided logger.
 // See layout.Image.Layers() method
-func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func() (v1.Hash, error)) error {
+func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func() (v1.Hash, error), progress *imgutil.ProgressTracker, logger imgutil.Logger) error {
 	if hash.Hex == "" && renamer == nil {
 		panic("writeBlob called an invalid hash and no renamer")
 	}
@@ -208,6 +278,9 @@ func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func
 	// Check if blob already exists and is the correct size
 	file := filepath.Join(dir, hash.Hex)
 	if s, err := os.Stat(file); err == nil && !s.IsDir() && (s.Size() == size || size == -1) {
+		logger.Debug("skipping blob that already exists", "digest", hash.String(), "path", l.Path)
+		// the size of a streamed layer is not known, so report the size of the existing blob
+		progress.SkipLayer(hash.String(), s.Size())
 		return nil
 	}
 
@@ -224,7 +297,7 @@ func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func
 		// Delete temp file if an error is encountered before renaming
 		defer func() {
 			if err := os.Remove(w.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
-				logs.Warn.Printf("error removing temporary file after encountering an error while writing blob: %v", err)
+				logger.Warn("error removing temporary file after encountering an error while writing blob", "path", w.Name(), "error", err)
 			}
 		}()
 	}
@@ -232,7 +305,7 @@ func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func
 
 	// Write to file and exit if not renaming
 	var skip = false
-	if n, err := io.Copy(w, rc); err != nil || renamer == nil {
+	if n, err := io.Copy(w, progress.TrackLayer(hash.String(), size, rc)); err != nil || renamer == nil {
 		return err
 	} else if size != -1 && n != size {
 		if n != 0 {
@@ -258,6 +331,7 @@ func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func
 
 	// Remove the empty blob when is skipped
 	if skip {
+		logger.Debug("skipping blob without data", "digest", hash.String(), "path", l.Path)
 		os.Remove(file)
 		return nil
 	}
@@ -271,3 +345,68 @@ func (l Path) writeBlob(hash v1.Hash, size int64, rc io.ReadCloser, renamer func
 	renamePath := l.append("blobs", finalHash.Algorithm, finalHash.Hex)
 	return os.Rename(w.Name(), renamePath)
 }
+
+// writeIndex writes the manifests referenced by the provided index, including their configs and layers, and then
+// the index itself as the layout `index.json`. Nested indexes are written as blobs.
+// Layers without data (see layout.Image.Layers()) are skipped.
+func (l Path) writeIndex(ii v1.ImageIndex) error {
+	if err := l.writeIndexManifests(ii); err != nil {
+		return err
+	}
+
+	rawIndex, err := ii.RawManifest()
+	if err != nil {
+		return err
+	}
+	return l.WriteFile("index.json", rawIndex, os.ModePerm)
+}
+
+// writeIndexManifests writes the blobs for every manifest referenced by the provided index
+func (l Path) writeIndexManifests(ii v1.ImageIndex) error {
+	index, err := ii.IndexManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range index.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := ii.ImageIndex(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := l.writeIndexManifests(child); err != nil {
+				return err
+			}
+			rawIndex, err := child.RawManifest()
+			if err != nil {
+				return err
+			}
+			if err := l.WriteBlob(desc.Digest, io.NopCloser(bytes.NewReader(rawIndex))); err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			child, err := ii.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if _, ok := child.(*Image); !ok {
+				// wrap the image to tolerate missing layer blobs
+				child = &Image{Image: child}
+			}
+			layers, err := child.Layers()
+			if err != nil {
+				return err
+			}
+			for _, layer := range layers {
+				if err := l.writeLayer(layer, nil, imgutil.NopLogger{}); err != nil {
+					return err
+				}
+			}
+			if err := l.writeImage(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package local
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fetchLayers populates the layer paths of the base layers with the provided diff IDs. The layers are read from the
+// image exported by the daemon as a stream, which is closed as soon as every layer is found, and only the requested
+// layers are kept on disk, cached by diff ID so that they are fetched once for the life of the image.
+func (i *Image) fetchLayers(diffIDs ...string) error {
+	missing := map[string]bool{}
+	for _, diffID := range diffIDs {
+		for l, layer := range i.inspect.RootFS.Layers {
+			if layer == diffID && i.layerPaths[l] == "" && !i.setCachedLayerPath(l) {
+				missing[diffID] = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if _, err := i.tempDir(); err != nil {
+		return err
+	}
+
+	imageReader, err := i.docker.ImageSave(i.ctx, []string{i.inspect.ID})
+	if err != nil {
+		return errors.Wrapf(err, "saving base image with ID %q from the docker daemon", i.inspect.ID)
+	}
+	// closing without draining stops the export of the layers that are not needed
+	defer imageReader.Close()
+
+	tr := tar.NewReader(&contextReader{ctx: i.ctx, r: imageReader})
+	for len(missing) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading image from the docker daemon")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		diffID, err := i.cacheLayer(tr, path.Clean(strings.TrimPrefix(hdr.Name, "/")), missing)
+		if err != nil {
+			return errors.Wrapf(err, "reading %q from the docker daemon", hdr.Name)
+		}
+		delete(missing, diffID)
+	}
+
+	for l := range i.inspect.RootFS.Layers {
+		if i.layerPaths[l] == "" {
+			i.setCachedLayerPath(l)
+		}
+	}
+	for _, diffID := range diffIDs {
+		if missing[diffID] {
+			return fmt.Errorf("layer %q was not found in image with ID %q", diffID, i.inspect.ID)
+		}
+	}
+	return nil
+}
+
+// cacheLayer writes the tar entry with the provided name to the layer cache when it is one of the missing layers, and
+// returns its diff ID. Metadata files are skipped. An uncompressed blob named after its digest, as in OCI exports, is only
+// written when that digest is missing. Other entries, e.g. the layer.tar files of legacy exports, are hashed while written,
+// decompressing them when needed, and removed unless they are missing.
+func (i *Image) cacheLayer(r io.Reader, name string, missing map[string]bool) (string, error) {
+	if isMetadata(name) {
+		return "", nil
+	}
+
+	br := bufio.NewReader(r)
+	compressed, err := isGzip(br)
+	if err != nil {
+		return "", err
+	}
+
+	var layerReader io.Reader = br
+	if compressed {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		defer gzr.Close()
+		layerReader = gzr
+	} else if path.Dir(name) == "blobs/sha256" && !missing["sha256:"+path.Base(name)] {
+		return "", nil
+	}
+
+	f, err := ioutil.TempFile(i.tmpDir, "layer.*.tar")
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hasher), layerReader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	diffID := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	if !missing[diffID] {
+		return "", os.Remove(f.Name())
+	}
+	return diffID, os.Rename(f.Name(), i.cachedLayerPath(diffID))
+}
+
+// tempDir returns the directory holding the temporary files of the image, creating it the first time it is called
+func (i *Image) tempDir() (string, error) {
+	if i.tmpDir == "" {
+		tmpDir, err := ioutil.TempDir(i.scratchDir, "imgutil.local.image.")
+		if err != nil {
+			return "", errors.Wrap(err, "failed to create temp dir")
+		}
+		i.tmpDir = tmpDir
+	}
+	return i.tmpDir, nil
+}
+
+// setCachedLayerPath sets the path of the layer at the provided index if the layer is in the layer cache
+func (i *Image) setCachedLayerPath(idx int) bool {
+	if i.tmpDir == "" {
+		return false
+	}
+	layerPath := i.cachedLayerPath(i.inspect.RootFS.Layers[idx])
+	if _, err := os.Stat(layerPath); err != nil {
+		return false
+	}
+	i.layerPaths[idx] = layerPath
+	return true
+}
+
+func (i *Image) cachedLayerPath(diffID string) string {
+	return filepath.Join(i.tmpDir, strings.TrimPrefix(diffID, "sha256:")+".tar")
+}
+
+// isMetadata tells whether the entry of an exported image is a manifest, config or other file that is not a layer
+func isMetadata(name string) bool {
+	switch path.Base(name) {
+	case "oci-layout", "repositories", "json", "VERSION":
+		return true
+	}
+	return path.Ext(name) == ".json"
+}
+
+func isGzip(br *bufio.Reader) (bool, error) {
+	magic, err := br.Peek(2)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return magic[0] == 0x1f && magic[1] == 0x8b, nil
+}
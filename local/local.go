@@ -13,11 +13,19 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/go-connections/nat"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
 )
 
+var errAnnotationsNotSupported = errors.New("manifest annotations are not supported for images in the docker daemon")
+
 type Image struct {
 	docker           DockerClient
 	repoName         string
@@ -25,7 +33,15 @@ type Image struct {
 	layerPaths       []string
 	prevImage        *Image // reused layers will be fetched from prevImage
 	downloadBaseOnce *sync.Once
+	scratchDir       string // parent of tmpDir
+	tmpDir           string // temporary files of the image, removed by Close; base layers fetched from the daemon are cached there by diff ID
 	createdAt        time.Time
+	labelBaseImage   bool
+	ctx              context.Context
+	progress         imgutil.ProgressFunc
+	logger           imgutil.Logger
+	history          []v1.History // an entry for each layer and for each instruction not creating a layer
+	withHistory      bool
 }
 
 // DockerClient is subset of client.CommonAPIClient required by this package
@@ -36,14 +52,24 @@ type DockerClient interface {
 	ImageSave(ctx context.Context, images []string) (io.ReadCloser, error)
 	ImageRemove(ctx context.Context, image string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
 	Info(ctx context.Context) (types.Info, error)
+	ImageHistory(ctx context.Context, image string) ([]image.HistoryResponseItem, error)
 }
 
 // getters
 
+// Annotations always returns an empty map, as the docker daemon does not store manifest annotations.
+func (i *Image) Annotations() (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
 func (i *Image) Architecture() (string, error) {
 	return i.inspect.Architecture, nil
 }
 
+func (i *Image) Cmd() ([]string, error) {
+	return i.inspect.Config.Cmd, nil
+}
+
 func (i *Image) CreatedAt() (time.Time, error) {
 	createdAtTime := i.inspect.Created
 	createdTime, err := time.Parse(time.RFC3339Nano, createdAtTime)
@@ -59,13 +85,23 @@ func (i *Image) Entrypoint() ([]string, error) {
 }
 
 func (i *Image) Env(key string) (string, error) {
-	for _, envVar := range i.inspect.Config.Env {
-		parts := strings.Split(envVar, "=")
-		if parts[0] == key {
-			return parts[1], nil
-		}
+	val, _ := imgutil.EnvValue(i.inspect.Config.Env, i.inspect.Os, key)
+	return val, nil
+}
+
+func (i *Image) Envs() ([]imgutil.EnvVar, error) {
+	return imgutil.EnvVars(i.inspect.Config.Env), nil
+}
+
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	if i.inspect.Config.ExposedPorts == nil {
+		return nil, nil
 	}
-	return "", nil
+	ports := make(map[string]struct{}, len(i.inspect.Config.ExposedPorts))
+	for port := range i.inspect.Config.ExposedPorts {
+		ports[string(port)] = struct{}{}
+	}
+	return ports, nil
 }
 
 func (i *Image) Found() bool {
@@ -86,12 +122,12 @@ func (i *Image) GetLayer(diffID string) (io.ReadCloser, error) {
 			continue
 		}
 		if i.layerPaths[l] == "" {
-			if err := i.downloadBaseLayersOnce(); err != nil {
-				return nil, err
-			}
-			if i.layerPaths[l] == "" {
+			if !i.Found() {
 				return nil, fmt.Errorf("fetching layer %q from daemon", diffID)
 			}
+			if err := i.fetchLayers(diffID); err != nil {
+				return nil, errors.Wrapf(err, "fetching layer %q from daemon", diffID)
+			}
 		}
 		return os.Open(i.layerPaths[l])
 	}
@@ -99,6 +135,14 @@ func (i *Image) GetLayer(diffID string) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("image %q does not contain layer with diff ID %q", i.repoName, diffID)
 }
 
+func (i *Image) Healthcheck() (*v1.HealthConfig, error) {
+	return v1HealthConfig(i.inspect.Config.Healthcheck), nil
+}
+
+func (i *Image) History() ([]v1.History, error) {
+	return append([]v1.History{}, i.history...), nil
+}
+
 func (i *Image) Identifier() (imgutil.Identifier, error) {
 	return IDIdentifier{
 		ImageID: strings.TrimPrefix(i.inspect.ID, "sha256:"),
@@ -118,6 +162,30 @@ func (i *Image) Labels() (map[string]string, error) {
 	return copiedLabels, nil
 }
 
+// LayerDescriptors returns the descriptors of the layers of the image. The daemon stores uncompressed layers,
+// so the digest of a layer is its diff ID. The size of a layer is only known once the layer is on disk,
+// i.e. for added layers and base layers fetched from the daemon, and is 0 otherwise.
+func (i *Image) LayerDescriptors() ([]imgutil.LayerDescriptor, error) {
+	descriptors := make([]imgutil.LayerDescriptor, len(i.inspect.RootFS.Layers))
+	for idx, diffID := range i.inspect.RootFS.Layers {
+		var size int64
+		if i.layerPaths[idx] != "" {
+			fi, err := os.Stat(i.layerPaths[idx])
+			if err != nil {
+				return nil, errors.Wrapf(err, "getting size for layer %q", diffID)
+			}
+			size = fi.Size()
+		}
+		descriptors[idx] = imgutil.LayerDescriptor{
+			DiffID:    diffID,
+			Digest:    diffID,
+			MediaType: ggcrtypes.DockerUncompressedLayer,
+			Size:      size,
+		}
+	}
+	return descriptors, nil
+}
+
 func (i *Image) ManifestSize() (int64, error) {
 	return 0, nil
 }
@@ -134,6 +202,14 @@ func (i *Image) OSVersion() (string, error) {
 	return i.inspect.OsVersion, nil
 }
 
+func (i *Image) Shell() ([]string, error) {
+	return i.inspect.Config.Shell, nil
+}
+
+func (i *Image) StopSignal() (string, error) {
+	return i.inspect.Config.StopSignal, nil
+}
+
 func (i *Image) TopLayer() (string, error) {
 	all := i.inspect.RootFS.Layers
 
@@ -145,10 +221,18 @@ func (i *Image) TopLayer() (string, error) {
 	return topLayer, nil
 }
 
+func (i *Image) User() (string, error) {
+	return i.inspect.Config.User, nil
+}
+
 func (i *Image) Variant() (string, error) {
 	return i.inspect.Variant, nil
 }
 
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	return i.inspect.Config.Volumes, nil
+}
+
 func (i *Image) WorkingDir() (string, error) {
 	return i.inspect.Config.WorkingDir, nil
 }
@@ -159,10 +243,26 @@ func (i *Image) AnnotateRefName(refName string) error {
 	return nil
 }
 
+func (i *Image) AppendEnv(key, val string) error {
+	i.inspect.Config.Env = imgutil.AppendEnv(i.inspect.Config.Env, i.inspect.Os, key, val)
+	return nil
+}
+
+func (i *Image) PrependEnv(key, val string) error {
+	i.inspect.Config.Env = imgutil.PrependEnv(i.inspect.Config.Env, i.inspect.Os, key, val)
+	return nil
+}
+
 func (i *Image) Rename(name string) {
 	i.repoName = name
 }
 
+// SetAnnotation always returns an error, as the docker daemon does not store manifest annotations.
+// Use SetLabel to persist metadata on an image in the daemon.
+func (i *Image) SetAnnotation(key, val string) error {
+	return errAnnotationsNotSupported
+}
+
 func (i *Image) SetArchitecture(architecture string) error {
 	i.inspect.Architecture = architecture
 	return nil
@@ -179,21 +279,29 @@ func (i *Image) SetEntrypoint(ep ...string) error {
 }
 
 func (i *Image) SetEnv(key, val string) error {
-	ignoreCase := i.inspect.Os == "windows"
-	for idx, kv := range i.inspect.Config.Env {
-		parts := strings.SplitN(kv, "=", 2)
-		foundKey := parts[0]
-		searchKey := key
-		if ignoreCase {
-			foundKey = strings.ToUpper(foundKey)
-			searchKey = strings.ToUpper(searchKey)
-		}
-		if foundKey == searchKey {
-			i.inspect.Config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
-			return nil
-		}
+	i.inspect.Config.Env = imgutil.SetEnv(i.inspect.Config.Env, i.inspect.Os, key, val)
+	return nil
+}
+
+func (i *Image) SetExposedPorts(ports map[string]struct{}) error {
+	if ports == nil {
+		i.inspect.Config.ExposedPorts = nil
+		return nil
+	}
+	i.inspect.Config.ExposedPorts = make(nat.PortSet, len(ports))
+	for port := range ports {
+		i.inspect.Config.ExposedPorts[nat.Port(port)] = struct{}{}
 	}
-	i.inspect.Config.Env = append(i.inspect.Config.Env, fmt.Sprintf("%s=%s", key, val))
+	return nil
+}
+
+func (i *Image) SetHealthcheck(healthcheck *v1.HealthConfig) error {
+	i.inspect.Config.Healthcheck = containerHealthConfig(healthcheck)
+	return nil
+}
+
+func (i *Image) SetHistory(history []v1.History) error {
+	i.history = history
 	return nil
 }
 
@@ -218,11 +326,31 @@ func (i *Image) SetOSVersion(osVersion string) error {
 	return nil
 }
 
+func (i *Image) SetShell(shell ...string) error {
+	i.inspect.Config.Shell = shell
+	return nil
+}
+
+func (i *Image) SetStopSignal(signal string) error {
+	i.inspect.Config.StopSignal = signal
+	return nil
+}
+
+func (i *Image) SetUser(user string) error {
+	i.inspect.Config.User = user
+	return nil
+}
+
 func (i *Image) SetVariant(v string) error {
 	i.inspect.Variant = v
 	return nil
 }
 
+func (i *Image) SetVolumes(volumes map[string]struct{}) error {
+	i.inspect.Config.Volumes = volumes
+	return nil
+}
+
 func (i *Image) SetWorkingDir(dir string) error {
 	i.inspect.Config.WorkingDir = dir
 	return nil
@@ -231,25 +359,55 @@ func (i *Image) SetWorkingDir(dir string) error {
 // modifiers
 
 func (i *Image) AddLayer(path string) error {
+	diffID, err := layerDiffID(path)
+	if err != nil {
+		return errors.Wrap(err, "AddLayer")
+	}
+	return i.AddLayerWithDiffID(path, diffID)
+}
+
+// layerDiffID returns the diff ID of the uncompressed tarred layer at the provided path.
+func layerDiffID(path string) (string, error) {
 	f, err := os.Open(filepath.Clean(path))
 	if err != nil {
-		return errors.Wrapf(err, "AddLayer: open layer: %s", path)
+		return "", errors.Wrapf(err, "open layer: %s", path)
 	}
 	defer f.Close()
 	hasher := sha256.New()
 	if _, err := io.Copy(hasher, f); err != nil {
-		return errors.Wrapf(err, "AddLayer: calculate checksum: %s", path)
+		return "", errors.Wrapf(err, "calculate checksum: %s", path)
 	}
-	diffID := "sha256:" + hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size())))
-	return i.AddLayerWithDiffID(path, diffID)
+	return "sha256:" + hex.EncodeToString(hasher.Sum(make([]byte, 0, hasher.Size()))), nil
 }
 
 func (i *Image) AddLayerWithDiffID(path, diffID string) error {
+	return i.AddLayerWithDiffIDAndHistory(path, diffID, v1.History{})
+}
+
+func (i *Image) AddLayerWithDiffIDAndHistory(path, diffID string, history v1.History) error {
 	i.inspect.RootFS.Layers = append(i.inspect.RootFS.Layers, diffID)
 	i.layerPaths = append(i.layerPaths, path)
+	i.history = append(i.history, history)
 	return nil
 }
 
+// Close removes the temporary files created by the image and its previous image, i.e. the base layers fetched from the
+// daemon, the layers written when rebasing, the Windows base layer of a new image and the archives written by SaveFile.
+// The image must not be used once closed.
+func (i *Image) Close() error {
+	var err error
+	if i.prevImage != nil {
+		err = i.prevImage.Close()
+	}
+	if i.tmpDir != "" {
+		if removeErr := os.RemoveAll(i.tmpDir); removeErr != nil && err == nil {
+			err = removeErr
+		}
+		i.tmpDir = ""
+	}
+	return err
+}
+
 func (i *Image) Delete() error {
 	if !i.Found() {
 		return nil
@@ -258,13 +416,11 @@ func (i *Image) Delete() error {
 		Force:         true,
 		PruneChildren: true,
 	}
-	_, err := i.docker.ImageRemove(context.Background(), i.inspect.ID, options)
+	_, err := i.docker.ImageRemove(i.ctx, i.inspect.ID, options)
 	return err
 }
 
 func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
-	ctx := context.Background()
-
 	// FIND TOP LAYER
 	var keepLayersIdx int
 	for idx, diffID := range i.inspect.RootFS.Layers {
@@ -282,8 +438,30 @@ func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
 		return err
 	}
 
+	// SWITCH BASE HISTORY
+	newBaseHistory, err := newBase.History()
+	if err != nil {
+		return errors.Wrapf(err, "getting history for new base image %q", newBase.Name())
+	}
+	topLayers := len(i.inspect.RootFS.Layers) - keepLayersIdx
+	topHistory := historyAbove(imgutil.LayersHistory(i.history, len(i.inspect.RootFS.Layers)), keepLayersIdx)
+
 	// SWITCH BASE LAYERS
-	newBaseInspect, _, err := i.docker.ImageInspectWithRaw(ctx, newBase.Name())
+	if _, ok := newBase.(*Image); !ok {
+		if err := i.rebaseOnto(keepLayersIdx, newBase); err != nil {
+			return err
+		}
+		i.history = append(imgutil.LayersHistory(newBaseHistory, len(i.inspect.RootFS.Layers)-topLayers), topHistory...)
+		if i.labelBaseImage {
+			digest, err := imgutil.ManifestDigest(newBase)
+			if err != nil {
+				return errors.Wrapf(err, "getting digest for new base image %q", newBase.Name())
+			}
+			i.setBaseImageLabels(newBase.Name(), digest)
+		}
+		return nil
+	}
+	newBaseInspect, _, err := i.docker.ImageInspectWithRaw(i.ctx, newBase.Name())
 	if err != nil {
 		return errors.Wrapf(err, "read config for new base image %q", newBase)
 	}
@@ -291,6 +469,124 @@ func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
 	i.downloadBaseOnce = &sync.Once{}
 	i.inspect.RootFS.Layers = append(newBaseInspect.RootFS.Layers, i.inspect.RootFS.Layers[keepLayersIdx:]...)
 	i.layerPaths = append(make([]string, len(newBaseInspect.RootFS.Layers)), i.layerPaths[keepLayersIdx:]...)
+	i.history = append(imgutil.LayersHistory(newBaseHistory, len(newBaseInspect.RootFS.Layers)), topHistory...)
+	if i.labelBaseImage {
+		i.setBaseImageLabels(newBase.Name(), repoDigest(newBaseInspect))
+	}
+	return nil
+}
+
+// rebaseOnto switches the base layers of the image with the layers of a new base that is not stored in the daemon,
+// writing each of its layers to disk so that they can be loaded when the image is saved.
+func (i *Image) rebaseOnto(keepLayersIdx int, newBase imgutil.Image) error {
+	newBaseImage, err := imgutil.V1Image(newBase)
+	if err != nil {
+		return errors.Wrap(err, "reading new base")
+	}
+	newBaseConfig, err := newBaseImage.ConfigFile()
+	if err != nil {
+		return errors.Wrapf(err, "getting config file for new base image %q", newBase.Name())
+	}
+	newBaseLayers, err := newBaseImage.Layers()
+	if err != nil {
+		return errors.Wrapf(err, "getting layers for new base image %q", newBase.Name())
+	}
+
+	tmpDir, err := i.tempDir()
+	if err != nil {
+		return err
+	}
+
+	diffIDs := make([]string, len(newBaseLayers))
+	layerPaths := make([]string, len(newBaseLayers))
+	for idx, layer := range newBaseLayers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return errors.Wrapf(err, "getting diff ID for layer %d of new base image %q", idx, newBase.Name())
+		}
+		layerPath := filepath.Join(tmpDir, diffID.Hex+".tar")
+		if err := writeLayer(i.ctx, layer, layerPath); err != nil {
+			return errors.Wrapf(err, "writing layer %q of new base image %q", diffID, newBase.Name())
+		}
+		diffIDs[idx] = diffID.String()
+		layerPaths[idx] = layerPath
+	}
+
+	i.inspect.Os = newBaseConfig.OS
+	i.inspect.Architecture = newBaseConfig.Architecture
+	i.inspect.OsVersion = newBaseConfig.OSVersion
+	i.inspect.RootFS.Layers = append(diffIDs, i.inspect.RootFS.Layers[keepLayersIdx:]...)
+	i.layerPaths = append(layerPaths, i.layerPaths[keepLayersIdx:]...)
+	return nil
+}
+
+// v1HealthConfig converts the healthcheck of a container config to the healthcheck of an image config.
+func v1HealthConfig(healthcheck *container.HealthConfig) *v1.HealthConfig {
+	if healthcheck == nil {
+		return nil
+	}
+	return &v1.HealthConfig{
+		Test:        healthcheck.Test,
+		Interval:    healthcheck.Interval,
+		Timeout:     healthcheck.Timeout,
+		StartPeriod: healthcheck.StartPeriod,
+		Retries:     healthcheck.Retries,
+	}
+}
+
+// containerHealthConfig converts the healthcheck of an image config to the healthcheck of a container config.
+func containerHealthConfig(healthcheck *v1.HealthConfig) *container.HealthConfig {
+	if healthcheck == nil {
+		return nil
+	}
+	return &container.HealthConfig{
+		Test:        healthcheck.Test,
+		Interval:    healthcheck.Interval,
+		Timeout:     healthcheck.Timeout,
+		StartPeriod: healthcheck.StartPeriod,
+		Retries:     healthcheck.Retries,
+	}
+}
+
+// historyAbove returns the entries of the provided history from the entry of the layer at the provided index,
+// i.e. the history of the layers kept when rebasing the image onto a new base.
+func historyAbove(history []v1.History, layerIdx int) []v1.History {
+	for idx, h := range history {
+		if h.EmptyLayer {
+			continue
+		}
+		if layerIdx == 0 {
+			return history[idx:]
+		}
+		layerIdx--
+	}
+	return nil
+}
+
+func writeLayer(ctx context.Context, layer v1.Layer, path string) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, &contextReader{ctx: ctx, r: rc})
+	return err
+}
+
+// RemoveAnnotation always returns an error, as the docker daemon does not store manifest annotations.
+func (i *Image) RemoveAnnotation(key string) error {
+	return errAnnotationsNotSupported
+}
+
+func (i *Image) RemoveEnv(key string) error {
+	i.inspect.Config.Env = imgutil.RemoveEnv(i.inspect.Config.Env, i.inspect.Os, key)
 	return nil
 }
 
@@ -299,7 +595,53 @@ func (i *Image) RemoveLabel(key string) error {
 	return nil
 }
 
+func (i *Image) RemoveLayer(diffID string) error {
+	layerIdx, err := i.layerIndex(diffID)
+	if err != nil {
+		return err
+	}
+	history := imgutil.LayersHistory(i.history, len(i.inspect.RootFS.Layers))
+	above := historyAbove(history, layerIdx)
+	i.history = append(history[:len(history)-len(above)], above[1:]...)
+	i.inspect.RootFS.Layers = append(i.inspect.RootFS.Layers[:layerIdx], i.inspect.RootFS.Layers[layerIdx+1:]...)
+	i.layerPaths = append(i.layerPaths[:layerIdx], i.layerPaths[layerIdx+1:]...)
+	return nil
+}
+
+func (i *Image) ReplaceLayer(diffID, path string) error {
+	newDiffID, err := layerDiffID(path)
+	if err != nil {
+		return errors.Wrap(err, "ReplaceLayer")
+	}
+	layerIdx, err := i.layerIndex(diffID)
+	if err != nil {
+		return err
+	}
+	i.inspect.RootFS.Layers[layerIdx] = newDiffID
+	i.layerPaths[layerIdx] = path
+	return nil
+}
+
+// layerIndex returns the index of the layer with the provided diff ID, fetching the base layers from the daemon first:
+// the daemon only reuses the base layers it stores while they keep their position, which changes once a layer is removed or replaced.
+func (i *Image) layerIndex(diffID string) (int, error) {
+	for idx, layer := range i.inspect.RootFS.Layers {
+		if layer != diffID {
+			continue
+		}
+		if err := i.downloadBaseLayersOnce(); err != nil {
+			return 0, err
+		}
+		return idx, nil
+	}
+	return 0, fmt.Errorf("image %q does not contain layer with diff ID %q", i.repoName, diffID)
+}
+
 func (i *Image) ReuseLayer(diffID string) error {
+	return i.ReuseLayerWithHistory(diffID, v1.History{})
+}
+
+func (i *Image) ReuseLayerWithHistory(diffID string, history v1.History) error {
 	if i.prevImage == nil {
 		return errors.New("failed to reuse layer because no previous image was provided")
 	}
@@ -307,14 +649,131 @@ func (i *Image) ReuseLayer(diffID string) error {
 		return fmt.Errorf("failed to reuse layer because previous image %q was not found in daemon", i.prevImage.repoName)
 	}
 
-	if err := i.prevImage.downloadBaseLayersOnce(); err != nil {
-		return err
-	}
-
 	for l := range i.prevImage.inspect.RootFS.Layers {
 		if i.prevImage.inspect.RootFS.Layers[l] == diffID {
-			return i.AddLayerWithDiffID(i.prevImage.layerPaths[l], diffID)
+			if err := i.prevImage.fetchLayers(diffID); err != nil {
+				return errors.Wrapf(err, "fetching layer %q from previous image %q", diffID, i.prevImage.repoName)
+			}
+			return i.AddLayerWithDiffIDAndHistory(i.prevImage.layerPaths[l], diffID, history)
 		}
 	}
 	return fmt.Errorf("SHA %s was not found in %s", diffID, i.prevImage.Name())
 }
+
+// extras
+
+// UnderlyingImage exposes the image as a v1.Image, e.g. to use it as the new base of an image from another backend.
+// Layers are read with GetLayer, so base layers are only fetched from the daemon when they are accessed.
+func (i *Image) UnderlyingImage() (v1.Image, error) {
+	return partial.UncompressedToImage(&v1ImageCore{image: i})
+}
+
+// SetUnderlyingImage replaces the config and layers of the image with those of the provided image, e.g. to load an image
+// from another backend into the daemon, see imgutil.Copy. The bottom layers the image already has are kept, as the daemon
+// does not need them again, and the other layers are written to disk so that they can be loaded when the image is saved.
+func (i *Image) SetUnderlyingImage(image v1.Image) error {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "getting config file")
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "getting layers")
+	}
+
+	diffIDs := make([]string, len(layers))
+	layerPaths := make([]string, len(layers))
+	keepLayers := true
+	var tmpDir string
+	for idx, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return errors.Wrapf(err, "getting diff ID for layer %d", idx)
+		}
+		diffIDs[idx] = diffID.String()
+		keepLayers = keepLayers && idx < len(i.inspect.RootFS.Layers) && i.inspect.RootFS.Layers[idx] == diffIDs[idx]
+		if keepLayers {
+			layerPaths[idx] = i.layerPaths[idx]
+			continue
+		}
+		if tmpDir == "" {
+			if tmpDir, err = i.tempDir(); err != nil {
+				return err
+			}
+		}
+		layerPaths[idx] = filepath.Join(tmpDir, diffID.Hex+".tar")
+		if err := writeLayer(i.ctx, layer, layerPaths[idx]); err != nil {
+			return errors.Wrapf(err, "writing layer %q", diffID)
+		}
+	}
+
+	i.inspect.Os = configFile.OS
+	i.inspect.Architecture = configFile.Architecture
+	i.inspect.OsVersion = configFile.OSVersion
+	i.inspect.Variant = configFile.Variant
+	i.inspect.Config = containerConfig(configFile.Config)
+	i.inspect.RootFS.Layers = diffIDs
+	i.layerPaths = layerPaths
+	i.history = configFile.History
+	return nil
+}
+
+// v1ImageCore implements partial.UncompressedImageCore on top of a local.Image
+type v1ImageCore struct {
+	image *Image
+}
+
+func (c *v1ImageCore) RawConfigFile() ([]byte, error) {
+	return c.image.newConfigFile()
+}
+
+func (c *v1ImageCore) MediaType() (ggcrtypes.MediaType, error) {
+	return ggcrtypes.DockerManifestSchema2, nil
+}
+
+func (c *v1ImageCore) LayerByDiffID(diffID v1.Hash) (partial.UncompressedLayer, error) {
+	for _, layer := range c.image.inspect.RootFS.Layers {
+		if layer == diffID.String() {
+			return &v1Layer{image: c.image, diffID: diffID}, nil
+		}
+	}
+	return nil, fmt.Errorf("image %q does not contain layer with diff ID %q", c.image.repoName, diffID)
+}
+
+// v1Layer implements partial.UncompressedLayer, reading the layer contents with local.Image GetLayer
+type v1Layer struct {
+	image  *Image
+	diffID v1.Hash
+}
+
+func (l *v1Layer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *v1Layer) Uncompressed() (io.ReadCloser, error) {
+	return l.image.GetLayer(l.diffID.String())
+}
+
+func (l *v1Layer) MediaType() (ggcrtypes.MediaType, error) {
+	return ggcrtypes.DockerLayer, nil
+}
+
+// setBaseImageLabels records the name and manifest digest of the base image as labels,
+// as the docker daemon does not store manifest annotations.
+func (i *Image) setBaseImageLabels(name, digest string) {
+	if i.inspect.Config.Labels == nil {
+		i.inspect.Config.Labels = map[string]string{}
+	}
+	imgutil.SetBaseImageAnnotations(i.inspect.Config.Labels, name, digest)
+}
+
+// repoDigest returns the manifest digest of the provided image in the registry it was pulled from or pushed to,
+// or an empty string if the daemon does not know it.
+func repoDigest(inspect types.ImageInspect) string {
+	for _, repoDigest := range inspect.RepoDigests {
+		if idx := strings.LastIndex(repoDigest, "@"); idx != -1 {
+			return repoDigest[idx+1:]
+		}
+	}
+	return ""
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -15,10 +16,13 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
 	"github.com/buildpacks/imgutil/local"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
@@ -40,6 +44,17 @@ func newTestImageName() string {
 	return localTestRegistry.RepoName("pack-image-test-" + h.RandString(10))
 }
 
+func daemonArchitecture(daemonInfo types.Info) string {
+	switch daemonInfo.Architecture {
+	case "aarch64", "arm64":
+		return "arm64"
+	case "armv7l", "armv6l":
+		return "arm"
+	default:
+		return "amd64"
+	}
+}
+
 func testImage(t *testing.T, when spec.G, it spec.S) {
 	var (
 		dockerClient          client.CommonAPIClient
@@ -84,7 +99,7 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, err)
 
 				h.AssertEq(t, inspect.Os, daemonInfo.OSType)
-				h.AssertEq(t, inspect.Architecture, "amd64")
+				h.AssertEq(t, inspect.Architecture, daemonArchitecture(daemonInfo))
 				h.AssertEq(t, inspect.RootFS.Type, "layers")
 			})
 		})
@@ -134,6 +149,22 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 					h.AssertEq(t, len(inspect.RootFS.Layers), 0)
 				}
 			})
+
+			it("uses the daemon architecture when none is given", func() {
+				img, err := local.NewImage(
+					newTestImageName(),
+					dockerClient,
+					local.WithDefaultPlatform(imgutil.Platform{OS: daemonOS}),
+				)
+				h.AssertNil(t, err)
+
+				daemonInfo, err := dockerClient.Info(context.TODO())
+				h.AssertNil(t, err)
+
+				arch, err := img.Architecture()
+				h.AssertNil(t, err)
+				h.AssertEq(t, arch, daemonArchitecture(daemonInfo))
+			})
 		})
 
 		when("#FromBaseImage", func() {
@@ -432,6 +463,86 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("#WithContext", func() {
+			it("stops saving the image once the context is cancelled", func() {
+				repoName := newTestImageName()
+				ctx, cancel := context.WithCancel(context.Background())
+				img, err := local.NewImage(repoName, dockerClient, local.WithContext(ctx))
+				h.AssertNil(t, err)
+
+				cancel()
+
+				h.AssertError(t, img.Save(), "context canceled")
+				_, _, err = dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertEq(t, client.IsErrNotFound(err), true)
+			})
+		})
+
+		when("#WithProgress", func() {
+			it("reports the progress of the layers loaded into the daemon", func() {
+				if daemonOS == "windows" {
+					t.Skip("linux test")
+				}
+
+				repoName := newTestImageName()
+				var updates []imgutil.Progress
+				img, err := local.NewImage(repoName, dockerClient, local.WithProgress(func(p imgutil.Progress) {
+					updates = append(updates, p)
+				}))
+				h.AssertNil(t, err)
+				layerPath, err := h.CreateSingleFileLayerTar("/progress.txt", "some-content", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				h.AssertNil(t, img.AddLayer(layerPath))
+				defer h.DockerRmi(dockerClient, repoName)
+
+				h.AssertNil(t, img.Save())
+
+				fi, err := os.Stat(layerPath)
+				h.AssertNil(t, err)
+				h.AssertEq(t, updates[0], imgutil.Progress{Layer: h.FileDiffID(t, layerPath), Complete: 0, Total: fi.Size()})
+				h.AssertEq(t, updates[len(updates)-1], imgutil.Progress{Complete: fi.Size(), Total: fi.Size()})
+			})
+		})
+
+		when("#WithHistory", func() {
+			it("keeps the history of the base image and of the added layers", func() {
+				if daemonOS == "windows" {
+					t.Skip("linux test")
+				}
+
+				baseImageName := newTestImageName()
+				baseImage, err := local.NewImage(baseImageName, dockerClient, local.WithHistory())
+				h.AssertNil(t, err)
+				baseLayerPath, err := h.CreateSingleFileLayerTar("/base.txt", "base-content", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(baseLayerPath)
+				h.AssertNil(t, baseImage.AddLayerWithDiffIDAndHistory(baseLayerPath, h.FileDiffID(t, baseLayerPath), v1.History{CreatedBy: "base-layer", Comment: "some-comment"}))
+				h.AssertNil(t, baseImage.Save())
+				defer h.DockerRmi(dockerClient, baseImageName)
+
+				repoName := newTestImageName()
+				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(baseImageName), local.WithHistory())
+				h.AssertNil(t, err)
+				appLayerPath, err := h.CreateSingleFileLayerTar("/app.txt", "app-content", daemonOS)
+				h.AssertNil(t, err)
+				defer os.Remove(appLayerPath)
+				h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(appLayerPath, h.FileDiffID(t, appLayerPath), v1.History{CreatedBy: "app-layer"}))
+				h.AssertNil(t, img.Save())
+				defer h.DockerRmi(dockerClient, repoName)
+
+				history, err := dockerClient.ImageHistory(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(history), 2)
+				h.AssertEq(t, history[0].CreatedBy, "app-layer")
+				h.AssertEq(t, history[1].CreatedBy, "base-layer")
+				h.AssertEq(t, history[1].Comment, "some-comment")
+				for _, entry := range history {
+					h.AssertEq(t, entry.Created, imgutil.NormalizedDateTime.Unix())
+				}
+			})
+		})
 	})
 
 	when("#Labels", func() {
@@ -554,6 +665,7 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertNil(t, err)
 
 				h.AssertNil(t, existingImage.SetEnv("MY_VAR", "my_val"))
+				h.AssertNil(t, existingImage.SetEnv("JAVA_OPTS", "-Dx=y"))
 				h.AssertNil(t, existingImage.Save())
 			})
 
@@ -570,6 +682,15 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, val, "my_val")
 			})
 
+			it("returns values containing =", func() {
+				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+				h.AssertNil(t, err)
+
+				val, err := img.Env("JAVA_OPTS")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "-Dx=y")
+			})
+
 			it("returns an empty string for a missing label", func() {
 				img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
 				h.AssertNil(t, err)
@@ -592,6 +713,41 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Envs #RemoveEnv #AppendEnv #PrependEnv", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("manages the environment in order", func() {
+			sep := ":"
+			if daemonOS == "windows" {
+				sep = ";"
+			}
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetEnv("MY_PATH", "/usr/bin"))
+			h.AssertNil(t, img.SetEnv("JAVA_OPTS", "-Dx=y"))
+			h.AssertNil(t, img.SetEnv("REMOVED", "some-val"))
+			h.AssertNil(t, img.AppendEnv("MY_PATH", "/app/bin"))
+			h.AssertNil(t, img.PrependEnv("MY_PATH", "/layers/bin"))
+			h.AssertNil(t, img.RemoveEnv("REMOVED"))
+
+			h.AssertNil(t, img.Save())
+
+			savedImg, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			envs, err := savedImg.Envs()
+			h.AssertNil(t, err)
+			h.AssertEq(t, envs, []imgutil.EnvVar{
+				{Key: "MY_PATH", Value: "/layers/bin" + sep + "/usr/bin" + sep + "/app/bin"},
+				{Key: "JAVA_OPTS", Value: "-Dx=y"},
+			})
+		})
+	})
+
 	when("#WorkingDir", func() {
 		when("image exists", func() {
 			var repoName = newTestImageName()
@@ -784,6 +940,20 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetAnnotation #RemoveAnnotation", func() {
+		it("returns an error", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertError(t, img.SetAnnotation("some-key", "some-value"), "manifest annotations are not supported")
+			h.AssertError(t, img.RemoveAnnotation("some-key"), "manifest annotations are not supported")
+
+			annotations, err := img.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(annotations), 0)
+		})
+	})
+
 	when("#SetLabel", func() {
 		var (
 			img           imgutil.Image
@@ -1059,6 +1229,50 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetUser #SetExposedPorts #SetVolumes #SetStopSignal #SetShell #SetHealthcheck", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("sets the runtime config", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+
+			healthcheck := &v1.HealthConfig{Test: []string{"CMD", "some-check"}, Interval: time.Second, Retries: 3}
+			h.AssertNil(t, img.SetUser("some-user"))
+			h.AssertNil(t, img.SetExposedPorts(map[string]struct{}{"8080/tcp": {}}))
+			h.AssertNil(t, img.SetVolumes(map[string]struct{}{"/some/volume": {}}))
+			h.AssertNil(t, img.SetStopSignal("SIGKILL"))
+			h.AssertNil(t, img.SetShell("/bin/bash", "-c"))
+			h.AssertNil(t, img.SetHealthcheck(healthcheck))
+
+			h.AssertNil(t, img.Save())
+
+			savedImg, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			user, err := savedImg.User()
+			h.AssertNil(t, err)
+			h.AssertEq(t, user, "some-user")
+			ports, err := savedImg.ExposedPorts()
+			h.AssertNil(t, err)
+			h.AssertEq(t, ports, map[string]struct{}{"8080/tcp": {}})
+			volumes, err := savedImg.Volumes()
+			h.AssertNil(t, err)
+			h.AssertEq(t, volumes, map[string]struct{}{"/some/volume": {}})
+			stopSignal, err := savedImg.StopSignal()
+			h.AssertNil(t, err)
+			h.AssertEq(t, stopSignal, "SIGKILL")
+			shell, err := savedImg.Shell()
+			h.AssertNil(t, err)
+			h.AssertEq(t, shell, []string{"/bin/bash", "-c"})
+			savedHealthcheck, err := savedImg.Healthcheck()
+			h.AssertNil(t, err)
+			h.AssertEq(t, savedHealthcheck, healthcheck)
+		})
+	})
+
 	when("#SetOS", func() {
 		var repoName = newTestImageName()
 
@@ -1256,6 +1470,89 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, afterInspect.OsVersion, beforeInspect.OsVersion)
 				h.AssertEq(t, afterInspect.Architecture, beforeInspect.Architecture)
 			})
+
+			when("the new base is a layout image", func() {
+				it("switches the base", func() {
+					if daemonOS == "windows" {
+						t.Skip("linux test")
+					}
+
+					tmpDir, err := ioutil.TempDir("", "local-rebase-layout")
+					h.AssertNil(t, err)
+					defer os.RemoveAll(tmpDir)
+
+					newBaseImg, err := layout.NewImage(filepath.Join(tmpDir, "new-base"), layout.WithDefaultPlatform(imgutil.Platform{
+						OS:           daemonOS,
+						Architecture: "amd64",
+					}))
+					h.AssertNil(t, err)
+					newBaseLayerPath, err := h.CreateSingleFileLayerTar("/new-base.txt", "new-base-from-layout", daemonOS)
+					h.AssertNil(t, err)
+					defer os.Remove(newBaseLayerPath)
+					h.AssertNil(t, newBaseImg.AddLayer(newBaseLayerPath))
+					h.AssertNil(t, newBaseImg.Save())
+
+					img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.Rebase(oldTopLayer, newBaseImg))
+					h.AssertNil(t, img.Save())
+
+					afterInspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+					h.AssertNil(t, err)
+					h.AssertEq(t, afterInspect.RootFS.Layers, []string{h.FileDiffID(t, newBaseLayerPath), imgLayer1DiffID, imgLayer2DiffID})
+				})
+			})
+
+			when("#WithBaseImageAnnotations", func() {
+				it("records the base image as labels and updates them with the new base", func() {
+					img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(oldBase), local.WithBaseImageAnnotations())
+					h.AssertNil(t, err)
+
+					label, err := img.Label(imgutil.BaseImageNameKey)
+					h.AssertNil(t, err)
+					h.AssertEq(t, label, oldBase)
+
+					newBaseImg, err := local.NewImage(newBase, dockerClient, local.FromBaseImage(newBase))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.Rebase(oldTopLayer, newBaseImg))
+					h.AssertNil(t, img.Save())
+
+					afterInspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+					h.AssertNil(t, err)
+					h.AssertEq(t, afterInspect.Config.Labels[imgutil.BaseImageNameKey], newBase)
+				})
+			})
+		})
+	})
+
+	when("#Copy", func() {
+		var repoName = newTestImageName()
+
+		it.After(func() {
+			h.AssertNil(t, h.DockerRmi(dockerClient, repoName))
+		})
+
+		it("loads the config and layers of an image from another backend", func() {
+			tmpDir, err := ioutil.TempDir("", "local-copy-layout")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(tmpDir)
+
+			src, err := layout.NewImage(filepath.Join(tmpDir, "src"), layout.WithDefaultPlatform(imgutil.Platform{OS: daemonOS, Architecture: "amd64"}))
+			h.AssertNil(t, err)
+			layerPath, err := h.CreateSingleFileLayerTar("/copied.txt", "copied", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, src.AddLayer(layerPath))
+			h.AssertNil(t, src.SetLabel("some-label", "some-value"))
+
+			dst, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+			h.AssertNil(t, imgutil.Copy(src, dst))
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.RootFS.Layers, []string{h.FileDiffID(t, layerPath)})
+			h.AssertEq(t, inspect.Config.Labels["some-label"], "some-value")
 		})
 	})
 
@@ -1398,6 +1695,86 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#LayerDescriptors #RemoveLayer #ReplaceLayer", func() {
+		var (
+			repoName                   = newTestImageName()
+			baseImageName              = newTestImageName()
+			layer1DiffID, layer2DiffID string
+		)
+
+		it.Before(func() {
+			baseImage, err := local.NewImage(baseImageName, dockerClient, local.FromBaseImage(runnableBaseImageName))
+			h.AssertNil(t, err)
+
+			layer1Path, err := h.CreateSingleFileLayerTar("/layer-1.txt", "layer-1", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layer1Path)
+			layer1DiffID = h.FileDiffID(t, layer1Path)
+			layer2Path, err := h.CreateSingleFileLayerTar("/layer-2.txt", "layer-2", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layer2Path)
+			layer2DiffID = h.FileDiffID(t, layer2Path)
+
+			h.AssertNil(t, baseImage.AddLayer(layer1Path))
+			h.AssertNil(t, baseImage.AddLayer(layer2Path))
+			h.AssertNil(t, baseImage.Save())
+		})
+
+		it.After(func() {
+			// the image is not saved by every spec
+			_ = h.DockerRmi(dockerClient, repoName, baseImageName)
+		})
+
+		it("describes the layers as stored by the daemon", func() {
+			img, err := local.NewImage(repoName, dockerClient)
+			h.AssertNil(t, err)
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			descriptors, err := img.LayerDescriptors()
+			h.AssertNil(t, err)
+			fi, err := os.Stat(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, descriptors, []imgutil.LayerDescriptor{{
+				DiffID:    h.FileDiffID(t, layerPath),
+				Digest:    h.FileDiffID(t, layerPath),
+				MediaType: ggcrtypes.DockerUncompressedLayer,
+				Size:      fi.Size(),
+			}})
+		})
+
+		it("removes and replaces base layers", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(baseImageName))
+			h.AssertNil(t, err)
+			origTopLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, origTopLayer, layer2DiffID)
+
+			newLayerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(newLayerPath)
+			newLayerDiffID := h.FileDiffID(t, newLayerPath)
+
+			h.AssertNil(t, img.RemoveLayer(layer1DiffID))
+			h.AssertNil(t, img.ReplaceLayer(layer2DiffID, newLayerPath))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, newLayerDiffID, h.StringElementAt(inspect.RootFS.Layers, -1))
+			h.AssertDoesNotContain(t, inspect.RootFS.Layers, layer1DiffID)
+			h.AssertDoesNotContain(t, inspect.RootFS.Layers, layer2DiffID)
+		})
+
+		it("errors when the layer does not exist", func() {
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(baseImageName))
+			h.AssertNil(t, err)
+			h.AssertError(t, img.RemoveLayer("sha256:some-missing-layer"), `does not contain layer with diff ID "sha256:some-missing-layer"`)
+		})
+	})
+
 	when("#AddLayerWithDiffID", func() {
 		it("appends a layer", func() {
 			repoName := newTestImageName()
@@ -1498,6 +1875,25 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 					}
 					h.AssertEq(t, string(contents), "file-contents")
 				})
+
+				it("fetches only the requested layer from the daemon", func() {
+					img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+					h.AssertNil(t, err)
+
+					topLayer, err := img.TopLayer()
+					h.AssertNil(t, err)
+
+					r, err := img.GetLayer(topLayer)
+					h.AssertNil(t, err)
+					h.AssertNil(t, r.Close())
+
+					descriptors, err := img.LayerDescriptors()
+					h.AssertNil(t, err)
+					for _, descriptor := range descriptors[:len(descriptors)-1] {
+						h.AssertEq(t, descriptor.Size, int64(0))
+					}
+					h.AssertEq(t, descriptors[len(descriptors)-1].Size > 0, true)
+				})
 			})
 
 			when("the layer does not exist", func() {
@@ -1909,6 +2305,35 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Close", func() {
+		it("removes the temporary files of the image from the scratch dir", func() {
+			scratchDir, err := os.MkdirTemp("", "imgutil.local.scratch.")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(scratchDir)
+
+			img, err := local.NewImage(newTestImageName(), dockerClient,
+				local.FromBaseImage(runnableBaseImageName),
+				local.WithScratchDir(scratchDir),
+			)
+			h.AssertNil(t, err)
+
+			topLayer, err := img.TopLayer()
+			h.AssertNil(t, err)
+			r, err := img.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			h.AssertNil(t, r.Close())
+
+			path, err := img.SaveFile()
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.HasPrefix(path, scratchDir), true)
+
+			h.AssertNil(t, img.Close())
+			entries, err := os.ReadDir(scratchDir)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(entries), 0)
+		})
+	})
+
 	when("#Found", func() {
 		when("it exists", func() {
 			var repoName = newTestImageName()
@@ -8,10 +8,13 @@ import (
 	"io"
 	"io/ioutil"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/imgutil"
@@ -27,16 +30,31 @@ func NewImage(repoName string, dockerClient DockerClient, ops ...ImageOption) (*
 		}
 	}
 
-	platform, err := defaultPlatform(dockerClient)
+	ctx := context.Background()
+	if imageOpts.ctx != nil {
+		ctx = imageOpts.ctx
+	}
+
+	daemonInfo, err := dockerClient.Info(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	platform := defaultPlatform(daemonInfo)
+	var requiredPlatform imgutil.Platform
+
 	if (imageOpts.platform != imgutil.Platform{}) {
 		if err := validatePlatformOption(platform, imageOpts.platform); err != nil {
 			return nil, err
 		}
-		platform = imageOpts.platform
+		if imageOpts.platform.Architecture != "" && supportsMultiPlatform(daemonInfo) {
+			requiredPlatform = imgutil.Platform{
+				OS:           imageOpts.platform.OS,
+				Architecture: imageOpts.platform.Architecture,
+				Variant:      imageOpts.platform.Variant,
+			}
+		}
+		platform = platformWithDefaults(imageOpts.platform, platform)
 	}
 
 	inspect := defaultInspect(platform)
@@ -47,6 +65,15 @@ func NewImage(repoName string, dockerClient DockerClient, ops ...ImageOption) (*
 		inspect:          inspect,
 		layerPaths:       make([]string, len(inspect.RootFS.Layers)),
 		downloadBaseOnce: &sync.Once{},
+		labelBaseImage:   imageOpts.baseImageLabels,
+		ctx:              ctx,
+		progress:         imageOpts.progress,
+		logger:           imageOpts.logger,
+		withHistory:      imageOpts.history,
+		scratchDir:       imageOpts.scratchDir,
+	}
+	if image.logger == nil {
+		image.logger = imgutil.NopLogger{}
 	}
 
 	if imageOpts.prevImageRepoName != "" {
@@ -56,7 +83,7 @@ func NewImage(repoName string, dockerClient DockerClient, ops ...ImageOption) (*
 	}
 
 	if imageOpts.baseImageRepoName != "" {
-		if err := processBaseImageOption(image, imageOpts.baseImageRepoName, platform, dockerClient); err != nil {
+		if err := processBaseImageOption(image, imageOpts.baseImageRepoName, platform, requiredPlatform, dockerClient); err != nil {
 			return nil, err
 		}
 	}
@@ -77,19 +104,61 @@ func NewImage(repoName string, dockerClient DockerClient, ops ...ImageOption) (*
 		image.inspect.Config = imageOpts.config
 	}
 
+	if image.labelBaseImage && imageOpts.baseImageRepoName != "" && image.inspect.ID != "" {
+		image.setBaseImageLabels(imageOpts.baseImageRepoName, repoDigest(image.inspect))
+	}
+
 	return image, nil
 }
 
-func defaultPlatform(dockerClient DockerClient) (imgutil.Platform, error) {
-	daemonInfo, err := dockerClient.Info(context.Background())
-	if err != nil {
-		return imgutil.Platform{}, err
-	}
-
-	return imgutil.Platform{
+// defaultPlatform returns the platform of the daemon, converting the machine hardware name reported by the daemon,
+// e.g. x86_64 or aarch64, to an OCI architecture and variant.
+func defaultPlatform(daemonInfo types.Info) imgutil.Platform {
+	platform := imgutil.Platform{
 		OS:           daemonInfo.OSType,
 		Architecture: "amd64",
-	}, nil
+	}
+
+	switch daemonInfo.Architecture {
+	case "":
+	case "x86_64", "x86-64", "amd64":
+		platform.Architecture = "amd64"
+	case "aarch64", "arm64":
+		platform.Architecture = "arm64"
+	case "armv7l", "armhf":
+		platform.Architecture, platform.Variant = "arm", "v7"
+	case "armv6l", "armel":
+		platform.Architecture, platform.Variant = "arm", "v6"
+	case "i386", "i686":
+		platform.Architecture = "386"
+	default:
+		platform.Architecture = daemonInfo.Architecture
+	}
+
+	return platform
+}
+
+// supportsMultiPlatform tells whether the daemon can store images of platforms other than its own under a single name,
+// as with the containerd image store.
+func supportsMultiPlatform(daemonInfo types.Info) bool {
+	for _, status := range daemonInfo.DriverStatus {
+		if status[0] == "driver-type" && status[1] == "io.containerd.snapshotter.v1" {
+			return true
+		}
+	}
+	return false
+}
+
+// platformWithDefaults fills the OS, and the architecture and variant, missing from the platform option with those of the daemon.
+func platformWithDefaults(optionPlatform imgutil.Platform, defaultPlatform imgutil.Platform) imgutil.Platform {
+	if optionPlatform.OS == "" {
+		optionPlatform.OS = defaultPlatform.OS
+	}
+	if optionPlatform.Architecture == "" {
+		optionPlatform.Architecture = defaultPlatform.Architecture
+		optionPlatform.Variant = defaultPlatform.Variant
+	}
+	return optionPlatform
 }
 
 func validatePlatformOption(defaultPlatform imgutil.Platform, optionPlatform imgutil.Platform) error {
@@ -104,17 +173,34 @@ func defaultInspect(platform imgutil.Platform) types.ImageInspect {
 	return types.ImageInspect{
 		Os:           platform.OS,
 		Architecture: platform.Architecture,
+		Variant:      platform.Variant,
 		OsVersion:    platform.OSVersion,
 		Config:       &container.Config{},
 	}
 }
 
+func inspectPlatform(inspect types.ImageInspect) v1.Platform {
+	return v1.Platform{
+		OS:           inspect.Os,
+		Architecture: inspect.Architecture,
+		Variant:      inspect.Variant,
+		OSVersion:    inspect.OsVersion,
+	}
+}
+
+func platformString(platform v1.Platform) string {
+	if platform.Variant != "" {
+		return platform.OS + "/" + platform.Architecture + "/" + platform.Variant
+	}
+	return platform.OS + "/" + platform.Architecture
+}
+
 func processPreviousImageOption(image *Image, prevImageRepoName string, platform imgutil.Platform, dockerClient DockerClient) error {
-	if _, err := inspectOptionalImage(dockerClient, prevImageRepoName, platform); err != nil {
+	if _, err := inspectOptionalImage(image.ctx, dockerClient, prevImageRepoName, platform); err != nil {
 		return err
 	}
 
-	prevImage, err := NewImage(prevImageRepoName, dockerClient, FromBaseImage(prevImageRepoName))
+	prevImage, err := NewImage(prevImageRepoName, dockerClient, FromBaseImage(prevImageRepoName), WithContext(image.ctx), WithLogger(image.logger), WithScratchDir(image.scratchDir))
 	if err != nil {
 		return errors.Wrapf(err, "getting previous image %q", prevImageRepoName)
 	}
@@ -124,13 +210,13 @@ func processPreviousImageOption(image *Image, prevImageRepoName string, platform
 	return nil
 }
 
-func inspectOptionalImage(docker DockerClient, imageName string, platform imgutil.Platform) (types.ImageInspect, error) {
+func inspectOptionalImage(ctx context.Context, docker DockerClient, imageName string, platform imgutil.Platform) (types.ImageInspect, error) {
 	var (
 		err     error
 		inspect types.ImageInspect
 	)
 
-	if inspect, _, err = docker.ImageInspectWithRaw(context.Background(), imageName); err != nil {
+	if inspect, _, err = docker.ImageInspectWithRaw(ctx, imageName); err != nil {
 		if client.IsErrNotFound(err) {
 			return defaultInspect(platform), nil
 		}
@@ -141,18 +227,55 @@ func inspectOptionalImage(docker DockerClient, imageName string, platform imguti
 	return inspect, nil
 }
 
-func processBaseImageOption(image *Image, baseImageRepoName string, platform imgutil.Platform, dockerClient DockerClient) error {
-	inspect, err := inspectOptionalImage(dockerClient, baseImageRepoName, platform)
+// processBaseImageOption uses the base image as the config and layers of the image. When a platform is required, as for
+// daemons storing images of many platforms, an error naming the platform of the base image is returned when it is
+// another platform, as the image of the requested platform cannot be selected when inspecting the image.
+func processBaseImageOption(image *Image, baseImageRepoName string, platform, requiredPlatform imgutil.Platform, dockerClient DockerClient) error {
+	inspect, err := inspectOptionalImage(image.ctx, dockerClient, baseImageRepoName, platform)
 	if err != nil {
 		return err
 	}
 
+	if inspect.ID != "" && !imgutil.PlatformMatches(inspectPlatform(inspect), imgutil.V1Platform(requiredPlatform)) {
+		return fmt.Errorf("base image %q has platform %q, which does not match the requested platform %q",
+			baseImageRepoName, platformString(inspectPlatform(inspect)), platformString(imgutil.V1Platform(requiredPlatform)))
+	}
+
 	image.inspect = inspect
 	image.layerPaths = make([]string, len(image.inspect.RootFS.Layers))
+	image.history = make([]v1.History, len(image.inspect.RootFS.Layers))
+
+	if image.withHistory && inspect.ID != "" {
+		history, err := dockerClient.ImageHistory(image.ctx, inspect.ID)
+		if err != nil {
+			return errors.Wrapf(err, "getting history for image %q", baseImageRepoName)
+		}
+		baseHistory := v1History(history)
+		if imgutil.HistoryLayers(baseHistory) != len(inspect.RootFS.Layers) {
+			image.logger.Warn("history of base image does not match its layers, ignoring it", "image", baseImageRepoName)
+			return nil
+		}
+		image.history = baseHistory
+	}
 
 	return nil
 }
 
+// v1History converts the history reported by the daemon, newest entry first, to the history of an image config.
+// The daemon does not report whether an entry created a layer, so entries without content are considered empty layers.
+func v1History(history []image.HistoryResponseItem) []v1.History {
+	converted := make([]v1.History, len(history))
+	for idx, h := range history {
+		converted[len(history)-1-idx] = v1.History{
+			Created:    v1.Time{Time: time.Unix(h.Created, 0).UTC()},
+			CreatedBy:  h.CreatedBy,
+			Comment:    h.Comment,
+			EmptyLayer: h.Size == 0,
+		}
+	}
+	return converted
+}
+
 func prepareNewWindowsImage(image *Image) error {
 	// only append base layer to empty image
 	if len(image.inspect.RootFS.Layers) > 0 {
@@ -164,7 +287,12 @@ func prepareNewWindowsImage(image *Image) error {
 		return err
 	}
 
-	layerFile, err := ioutil.TempFile("", "imgutil.local.image.windowsbaselayer")
+	tmpDir, err := image.tempDir()
+	if err != nil {
+		return err
+	}
+
+	layerFile, err := ioutil.TempFile(tmpDir, "windowsbaselayer.*.tar")
 	if err != nil {
 		return errors.Wrap(err, "creating temp file")
 	}
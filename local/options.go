@@ -1,6 +1,7 @@
 package local
 
 import (
+	"context"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
@@ -16,9 +17,18 @@ type options struct {
 	prevImageRepoName string
 	createdAt         time.Time
 	config            *container.Config
+
+	baseImageLabels bool
+	ctx             context.Context
+	progress        imgutil.ProgressFunc
+	logger          imgutil.Logger
+	history         bool
+	scratchDir      string
 }
 
 // FromBaseImage loads an existing image as the config and layers for the new image.
+// When the daemon stores images of many platforms, as with the containerd image store, and WithDefaultPlatform provides
+// an architecture, the image must match the OS, architecture and variant of that platform, otherwise NewImage returns an error.
 // Ignored if image is not found.
 func FromBaseImage(imageName string) ImageOption {
 	return func(i *options) error {
@@ -27,6 +37,27 @@ func FromBaseImage(imageName string) ImageOption {
 	}
 }
 
+// WithBaseImageAnnotations records the name and, when the daemon knows it, the manifest digest of the image loaded with FromBaseImage
+// as the org.opencontainers.image.base.name and org.opencontainers.image.base.digest labels, as the docker daemon does not store
+// manifest annotations, and updates them with those of the new base when the image is rebased.
+func WithBaseImageAnnotations() ImageOption {
+	return func(opts *options) error {
+		opts.baseImageLabels = true
+		return nil
+	}
+}
+
+// WithContext lets a caller provide the context used by the docker daemon operations of the image,
+// i.e. loading the base and previous images, saving, fetching layers, rebasing and deleting.
+// Cancelling the context interrupts the transfer of the image to or from the daemon.
+// Defaults to context.Background().
+func WithContext(ctx context.Context) ImageOption {
+	return func(opts *options) error {
+		opts.ctx = ctx
+		return nil
+	}
+}
+
 // WithCreatedAt lets a caller set the created at timestamp for the image.
 // Defaults for a new image is imgutil.NormalizedDateTime
 func WithCreatedAt(createdAt time.Time) ImageOption {
@@ -44,6 +75,7 @@ func WithConfig(config *container.Config) ImageOption {
 }
 
 // WithDefaultPlatform provides Architecture/OS/OSVersion defaults for the new image.
+// The OS must match the daemon, and a missing OS or architecture defaults to that of the daemon.
 // Defaults for a new image are ignored when FromBaseImage returns an image.
 func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
 	return func(i *options) error {
@@ -52,6 +84,35 @@ func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
 	}
 }
 
+// WithProgress lets a caller receive the progress of the layers written to the docker daemon when the image is saved,
+// or to the archive when it is saved to a file.
+func WithProgress(fn imgutil.ProgressFunc) ImageOption {
+	return func(opts *options) error {
+		opts.progress = fn
+		return nil
+	}
+}
+
+// WithHistory keeps the history of the image when it is saved, i.e. the history of the base image reported by the daemon
+// and the entries provided with AddLayerWithDiffIDAndHistory and ReuseLayerWithHistory,
+// with the creation time of every entry set to the created at timestamp of the image.
+// Without it, the history is saved as an empty entry for each layer.
+func WithHistory() ImageOption {
+	return func(opts *options) error {
+		opts.history = true
+		return nil
+	}
+}
+
+// WithLogger lets a caller receive diagnostic messages about the operations of the image,
+// such as saves falling back to downloading the base layers. Defaults to imgutil.NopLogger.
+func WithLogger(logger imgutil.Logger) ImageOption {
+	return func(opts *options) error {
+		opts.logger = logger
+		return nil
+	}
+}
+
 // WithPreviousImage loads an existing image as a source for reusable layers.
 // Use with ReuseLayer().
 // Ignored if image is not found.
@@ -61,3 +122,14 @@ func WithPreviousImage(imageName string) ImageOption {
 		return nil
 	}
 }
+
+// WithScratchDir lets a caller choose the directory holding the temporary files of the image, such as the base layers
+// fetched from the daemon and the archives written by SaveFile. They are kept in a directory created in the provided
+// directory and removed by Close.
+// Defaults to os.TempDir().
+func WithScratchDir(dir string) ImageOption {
+	return func(opts *options) error {
+		opts.scratchDir = dir
+		return nil
+	}
+}
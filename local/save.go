@@ -9,7 +9,6 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
@@ -28,26 +27,27 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 	// during the first save attempt some layers may be excluded. The docker daemon allows this if the given set
 	// of layers already exists in the daemon in the given order
 	inspect, err := i.doSaveAs(name)
-	if err != nil {
+	if err != nil && i.ctx.Err() == nil {
 		// populate all layer paths and try again without the above performance optimization.
+		i.logger.Debug("saving image without the base layers failed, retrying with the base layers downloaded", "image", name, "error", err)
 		if err := i.downloadBaseLayersOnce(); err != nil {
 			return err
 		}
 
 		inspect, err = i.doSaveAs(name)
-		if err != nil {
-			saveErr := imgutil.SaveError{}
-			for _, n := range append([]string{name}, additionalNames...) {
-				saveErr.Errors = append(saveErr.Errors, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
-			}
-			return saveErr
+	}
+	if err != nil {
+		saveErr := imgutil.SaveError{}
+		for _, n := range append([]string{name}, additionalNames...) {
+			saveErr.Errors = append(saveErr.Errors, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
 		}
+		return saveErr
 	}
 	i.inspect = inspect
 
 	var errs []imgutil.SaveDiagnostic
 	for _, n := range append([]string{name}, additionalNames...) {
-		if err := i.docker.ImageTag(context.Background(), i.inspect.ID, n); err != nil {
+		if err := i.docker.ImageTag(i.ctx, i.inspect.ID, n); err != nil {
 			errs = append(errs, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
 		}
 	}
@@ -60,8 +60,8 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 }
 
 func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
-	ctx := context.Background()
-	done := make(chan error)
+	ctx := i.ctx
+	done := make(chan error, 1) // buffered so that the loader never blocks when the tar writer fails first
 
 	t, err := registryName.NewTag(name, registryName.WeakValidation)
 	if err != nil {
@@ -76,6 +76,7 @@ func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
 	go func() {
 		res, err := i.docker.ImageLoad(ctx, pr, true)
 		if err != nil {
+			pr.CloseWithError(err) // unblock the tar writer
 			done <- err
 			return
 		}
@@ -102,6 +103,11 @@ func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
 		return types.ImageInspect{}, errors.Wrap(err, "generating config file")
 	}
 
+	progress, err := i.newProgressTracker()
+	if err != nil {
+		return types.ImageInspect{}, err
+	}
+
 	id := fmt.Sprintf("%x", sha256.Sum256(configFile))
 	if err := addTextToTar(tw, id+".json", configFile); err != nil {
 		return types.ImageInspect{}, err
@@ -109,7 +115,7 @@ func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
 
 	var blankIdx int
 	var layerPaths []string
-	for _, path := range i.layerPaths {
+	for idx, path := range i.layerPaths {
 		if path == "" {
 			layerName := fmt.Sprintf("blank_%d", blankIdx)
 			blankIdx++
@@ -120,15 +126,9 @@ func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
 			layerPaths = append(layerPaths, layerName)
 		} else {
 			layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(path)))
-			f, err := os.Open(filepath.Clean(path))
-			if err != nil {
+			if err := addLayerToTar(ctx, tw, layerName, path, i.inspect.RootFS.Layers[idx], progress); err != nil {
 				return types.ImageInspect{}, err
 			}
-			defer f.Close()
-			if err := addFileToTar(tw, layerName, f); err != nil {
-				return types.ImageInspect{}, err
-			}
-			f.Close()
 			layerPaths = append(layerPaths, layerName)
 		}
 	}
@@ -155,7 +155,7 @@ func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
 		return types.ImageInspect{}, errors.Wrapf(err, "loading image %q. first error", i.repoName)
 	}
 
-	inspect, _, err := i.docker.ImageInspectWithRaw(context.Background(), id)
+	inspect, _, err := i.docker.ImageInspectWithRaw(ctx, id)
 	if err != nil {
 		if client.IsErrNotFound(err) {
 			return types.ImageInspect{}, errors.Wrapf(err, "saving image %q", i.repoName)
@@ -166,7 +166,26 @@ func (i *Image) doSaveAs(name string) (types.ImageInspect, error) {
 	return inspect, nil
 }
 
-// downloadBaseLayersOnce exports the base image from the daemon and populates layerPaths the first time it is called.
+// newProgressTracker returns a tracker for the layers of the image that are written when saving it, i.e. those with a path
+func (i *Image) newProgressTracker() (*imgutil.ProgressTracker, error) {
+	if i.progress == nil {
+		return nil, nil
+	}
+	var total int64
+	for _, path := range i.layerPaths {
+		if path == "" {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		total += fi.Size()
+	}
+	return imgutil.NewProgressTracker(i.progress, total), nil
+}
+
+// downloadBaseLayersOnce fetches the base layers missing from disk from the daemon the first time it is called.
 // subsequent calls do nothing.
 func (i *Image) downloadBaseLayersOnce() error {
 	var err error
@@ -183,62 +202,10 @@ func (i *Image) downloadBaseLayersOnce() error {
 }
 
 func (i *Image) downloadBaseLayers() error {
-	ctx := context.Background()
-
-	imageReader, err := i.docker.ImageSave(ctx, []string{i.inspect.ID})
-	if err != nil {
-		return errors.Wrapf(err, "saving base image with ID %q from the docker daemon", i.inspect.ID)
-	}
-	defer ensureReaderClosed(imageReader)
-
-	tmpDir, err := ioutil.TempDir("", "imgutil.local.image.")
-	if err != nil {
-		return errors.Wrap(err, "failed to create temp dir")
-	}
-
-	err = untar(imageReader, tmpDir)
-	if err != nil {
-		return err
-	}
-
-	mf, err := os.Open(filepath.Clean(filepath.Join(tmpDir, "manifest.json")))
-	if err != nil {
-		return err
-	}
-	defer mf.Close()
-
-	var manifest []struct {
-		Config string
-		Layers []string
-	}
-	if err := json.NewDecoder(mf).Decode(&manifest); err != nil {
+	if err := i.fetchLayers(i.inspect.RootFS.Layers...); err != nil {
 		return err
 	}
 
-	if len(manifest) != 1 {
-		return fmt.Errorf("manifest.json had unexpected number of entries: %d", len(manifest))
-	}
-
-	df, err := os.Open(filepath.Clean(filepath.Join(tmpDir, manifest[0].Config)))
-	if err != nil {
-		return err
-	}
-	defer df.Close()
-
-	var details struct {
-		RootFS struct {
-			DiffIDs []string `json:"diff_ids"`
-		} `json:"rootfs"`
-	}
-
-	if err = json.NewDecoder(df).Decode(&details); err != nil {
-		return err
-	}
-
-	for l := range details.RootFS.DiffIDs {
-		i.layerPaths[l] = filepath.Join(tmpDir, manifest[0].Layers[l])
-	}
-
 	for l := range i.layerPaths {
 		if i.layerPaths[l] == "" {
 			return errors.New("failed to download all base layers from daemon")
@@ -263,6 +230,19 @@ func checkResponseError(r io.Reader) error {
 	return nil
 }
 
+// contextReader stops reading once its context is done, so that long copies are interrupted on cancellation
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
 // ensureReaderClosed drains and closes and reader, returning the first error
 func ensureReaderClosed(r io.ReadCloser) error {
 	_, err := io.Copy(ioutil.Discard, r)
@@ -9,18 +9,27 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
 	registryName "github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/buildpacks/imgutil"
 )
 
+// SaveFile saves the image as a docker archive in the directory holding the temporary files of the image, see WithScratchDir,
+// and provides the filesystem location. The archive is removed by Close.
 func (i *Image) SaveFile() (string, error) {
-	f, err := os.CreateTemp("", "imgutil.local.image.export.*.tar")
+	tmpDir, err := i.tempDir()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(tmpDir, "export.*.tar")
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create temporary file")
 	}
@@ -38,7 +47,7 @@ func (i *Image) SaveFile() (string, error) {
 		return "", errors.Wrap(err, "failed to fetch base layers")
 	}
 
-	errs, _ := errgroup.WithContext(context.Background())
+	errs, ctx := errgroup.WithContext(i.ctx)
 	pr, pw := io.Pipe()
 
 	// File writer
@@ -65,24 +74,15 @@ func (i *Image) SaveFile() (string, error) {
 			return errors.Wrap(err, "failed to add config file to tar archive")
 		}
 
-		for _, path := range i.layerPaths {
-			err := func() error {
-				f, err := os.Open(filepath.Clean(path))
-				if err != nil {
-					return errors.Wrapf(err, "failed to open layer path: %s", path)
-				}
-				defer f.Close()
-
-				layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(path)))
-				if err := addFileToTar(tw, layerName, f); err != nil {
-					return errors.Wrapf(err, "failed to add layer to tar archive from path: %s", path)
-				}
-
-				return nil
-			}()
+		progress, err := i.newProgressTracker()
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer sizes")
+		}
 
-			if err != nil {
-				return err
+		for idx, path := range i.layerPaths {
+			layerName := fmt.Sprintf("/%x.tar", sha256.Sum256([]byte(path)))
+			if err := addLayerToTar(ctx, tw, layerName, path, i.inspect.RootFS.Layers[idx], progress); err != nil {
+				return errors.Wrapf(err, "failed to add layer to tar archive from path: %s", path)
 			}
 		}
 
@@ -123,25 +123,51 @@ func (i *Image) SaveFile() (string, error) {
 }
 
 func (i *Image) newConfigFile() ([]byte, error) {
-	cfg, err := v1Config(i.inspect, i.createdAt)
+	cfg, err := v1Config(i.inspect, i.createdAt, i.savedHistory())
 	if err != nil {
 		return nil, err
 	}
 	return json.Marshal(cfg)
 }
 
+// savedHistory returns the history written to the config of the image when it is saved, see WithHistory
+func (i *Image) savedHistory() []v1.History {
+	if i.withHistory {
+		return imgutil.NormalizedHistory(i.history, i.createdAt)
+	}
+	history := make([]v1.History, len(i.inspect.RootFS.Layers))
+	for j := range history {
+		// zero history
+		history[j] = v1.History{
+			Created: v1.Time{Time: i.createdAt},
+		}
+	}
+	return history
+}
+
 // helpers
 
-func addFileToTar(tw *tar.Writer, name string, contents *os.File) error {
-	fi, err := contents.Stat()
+// addLayerToTar adds the layer at the provided path to the tar, reporting the progress of the layer with the provided diff ID
+func addLayerToTar(ctx context.Context, tw *tar.Writer, name, path, diffID string, progress *imgutil.ProgressTracker) error {
+	f, err := os.Open(filepath.Clean(path))
 	if err != nil {
 		return err
 	}
-	hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size()}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return addFileToTar(tw, name, fi.Size(), progress.TrackLayer(diffID, fi.Size(), &contextReader{ctx: ctx, r: f}))
+}
+
+func addFileToTar(tw *tar.Writer, name string, size int64, contents io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size}
 	if err := tw.WriteHeader(hdr); err != nil {
 		return err
 	}
-	_, err = io.Copy(tw, contents)
+	_, err := io.Copy(tw, contents)
 	return err
 }
 
@@ -154,78 +180,7 @@ func addTextToTar(tw *tar.Writer, name string, contents []byte) error {
 	return err
 }
 
-func cleanPath(dest, header string) (string, error) {
-	joined := filepath.Join(dest, header)
-	if strings.HasPrefix(joined, filepath.Clean(dest)) {
-		return joined, nil
-	}
-	return "", fmt.Errorf("bad filepath: %s", header)
-}
-
-func untar(r io.Reader, dest string) error {
-	tr := tar.NewReader(r)
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			// end of tar archive
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-
-		path, err := cleanPath(dest, hdr.Name)
-		if err != nil {
-			return err
-		}
-
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(path, hdr.FileInfo().Mode()); err != nil {
-				return err
-			}
-		case tar.TypeReg, tar.TypeRegA:
-			_, err := os.Stat(filepath.Dir(path))
-			if os.IsNotExist(err) {
-				if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
-					return err
-				}
-			}
-
-			fh, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY, hdr.FileInfo().Mode())
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(fh, tr); err != nil {
-				fh.Close()
-				return err
-			} // #nosec G110
-			fh.Close()
-		case tar.TypeSymlink:
-			_, err := os.Stat(filepath.Dir(path))
-			if os.IsNotExist(err) {
-				if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
-					return err
-				}
-			}
-
-			if err := os.Symlink(hdr.Linkname, path); err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unknown file type in tar %d", hdr.Typeflag)
-		}
-	}
-}
-
-func v1Config(inspect types.ImageInspect, createdAt time.Time) (v1.ConfigFile, error) {
-	history := make([]v1.History, len(inspect.RootFS.Layers))
-	for i := range history {
-		// zero history
-		history[i] = v1.History{
-			Created: v1.Time{Time: createdAt},
-		}
-	}
+func v1Config(inspect types.ImageInspect, createdAt time.Time, history []v1.History) (v1.ConfigFile, error) {
 	diffIDs := make([]v1.Hash, len(inspect.RootFS.Layers))
 	for i, layer := range inspect.RootFS.Layers {
 		hash, err := v1.NewHash(layer)
@@ -240,22 +195,12 @@ func v1Config(inspect types.ImageInspect, createdAt time.Time) (v1.ConfigFile, e
 	}
 	var config v1.Config
 	if inspect.Config != nil {
-		var healthcheck *v1.HealthConfig
-		if inspect.Config.Healthcheck != nil {
-			healthcheck = &v1.HealthConfig{
-				Test:        inspect.Config.Healthcheck.Test,
-				Interval:    inspect.Config.Healthcheck.Interval,
-				Timeout:     inspect.Config.Healthcheck.Timeout,
-				StartPeriod: inspect.Config.Healthcheck.StartPeriod,
-				Retries:     inspect.Config.Healthcheck.Retries,
-			}
-		}
 		config = v1.Config{
 			AttachStderr:    inspect.Config.AttachStderr,
 			AttachStdin:     inspect.Config.AttachStdin,
 			AttachStdout:    inspect.Config.AttachStdout,
 			Cmd:             inspect.Config.Cmd,
-			Healthcheck:     healthcheck,
+			Healthcheck:     v1HealthConfig(inspect.Config.Healthcheck),
 			Domainname:      inspect.Config.Domainname,
 			Entrypoint:      inspect.Config.Entrypoint,
 			Env:             inspect.Config.Env,
@@ -290,3 +235,37 @@ func v1Config(inspect types.ImageInspect, createdAt time.Time) (v1.ConfigFile, e
 		Config: config,
 	}, nil
 }
+
+// containerConfig converts the config of an image config file to the config of the image in the daemon, see v1Config.
+func containerConfig(config v1.Config) *container.Config {
+	exposedPorts := make(nat.PortSet, len(config.ExposedPorts))
+	for key, val := range config.ExposedPorts {
+		exposedPorts[nat.Port(key)] = val
+	}
+	return &container.Config{
+		AttachStderr:    config.AttachStderr,
+		AttachStdin:     config.AttachStdin,
+		AttachStdout:    config.AttachStdout,
+		Cmd:             config.Cmd,
+		Healthcheck:     containerHealthConfig(config.Healthcheck),
+		Domainname:      config.Domainname,
+		Entrypoint:      config.Entrypoint,
+		Env:             config.Env,
+		Hostname:        config.Hostname,
+		Image:           config.Image,
+		Labels:          config.Labels,
+		OnBuild:         config.OnBuild,
+		OpenStdin:       config.OpenStdin,
+		StdinOnce:       config.StdinOnce,
+		Tty:             config.Tty,
+		User:            config.User,
+		Volumes:         config.Volumes,
+		WorkingDir:      config.WorkingDir,
+		ExposedPorts:    exposedPorts,
+		ArgsEscaped:     config.ArgsEscaped,
+		NetworkDisabled: config.NetworkDisabled,
+		MacAddress:      config.MacAddress,
+		StopSignal:      config.StopSignal,
+		Shell:           config.Shell,
+	}
+}
@@ -0,0 +1,15 @@
+package imgutil
+
+// Logger receives diagnostic messages about image operations, such as retries and fallbacks,
+// as a message followed by alternating keys and values, e.g. Debug("retrying", "image", name, "attempt", 2).
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+}
+
+// NopLogger discards all messages. It is the default Logger of images.
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...interface{}) {}
+
+func (NopLogger) Warn(string, ...interface{}) {}
@@ -0,0 +1,137 @@
+package imgutil
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// PlatformNotFoundError is returned by ImageFromIndex when no manifest of the index matches the requested platform.
+type PlatformNotFoundError struct {
+	Platform  v1.Platform
+	Available []v1.Platform
+}
+
+func (e PlatformNotFoundError) Error() string {
+	available := make([]string, len(e.Available))
+	for idx, platform := range e.Available {
+		available[idx] = platformString(platform)
+	}
+	return fmt.Sprintf("no manifest matching platform %q, available platforms: [%s]", platformString(e.Platform), strings.Join(available, ", "))
+}
+
+// V1Platform returns the v1.Platform for the provided Platform.
+func V1Platform(platform Platform) v1.Platform {
+	return v1.Platform{
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		OSVersion:    platform.OSVersion,
+		Variant:      platform.Variant,
+	}
+}
+
+// ImageFromIndex returns the first image of the provided index matching the provided platform, looking into nested
+// indexes in order. A manifest matches when its OS, architecture, variant and OS version equal those of the platform
+// that are not empty, and its OS features include those of the platform. Manifests without a platform in their
+// descriptor are matched with the platform recorded in their config file.
+// A PlatformNotFoundError listing the platforms of the index is returned when no manifest matches.
+func ImageFromIndex(index v1.ImageIndex, platform v1.Platform) (v1.Image, error) {
+	var available []v1.Platform
+	image, err := imageFromIndex(index, platform, &available)
+	if err != nil {
+		return nil, err
+	}
+	if image == nil {
+		return nil, PlatformNotFoundError{Platform: platform, Available: available}
+	}
+	return image, nil
+}
+
+// imageFromIndex returns the first image of the index matching the platform, or nil, adding the platforms of the
+// manifests of the index to available.
+func imageFromIndex(index v1.ImageIndex, platform v1.Platform, available *[]v1.Platform) (v1.Image, error) {
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range indexManifest.Manifests {
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			image, err := imageFromIndex(child, platform, available)
+			if err != nil || image != nil {
+				return image, err
+			}
+		case desc.MediaType.IsImage():
+			if desc.Platform != nil {
+				// the image is only loaded when its descriptor matches, as it may not be in the index, e.g. in a sparse layout
+				*available = append(*available, *desc.Platform)
+				if PlatformMatches(*desc.Platform, platform) {
+					return index.Image(desc.Digest)
+				}
+				continue
+			}
+			image, err := index.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			candidate, err := PlatformDescriptor(image)
+			if err != nil {
+				continue // the platform of the image is unknown
+			}
+			*available = append(*available, *candidate)
+			if PlatformMatches(*candidate, platform) {
+				return image, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// PlatformMatches tells whether the candidate platform satisfies the required platform: empty fields of the required
+// platform match any value, and the OS features of the candidate must include those of the required platform.
+func PlatformMatches(candidate, required v1.Platform) bool {
+	if required.OS != "" && candidate.OS != required.OS {
+		return false
+	}
+	if required.Architecture != "" && candidate.Architecture != required.Architecture {
+		return false
+	}
+	if required.Variant != "" && candidate.Variant != required.Variant {
+		return false
+	}
+	if required.OSVersion != "" && candidate.OSVersion != required.OSVersion {
+		return false
+	}
+	for _, feature := range required.OSFeatures {
+		if !contains(candidate.OSFeatures, feature) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// platformString returns the platform as os/architecture[/variant][:os version]
+func platformString(platform v1.Platform) string {
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+	if platform.OSVersion != "" {
+		s += ":" + platform.OSVersion
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package imgutil
+
+import (
+	"io"
+	"sync"
+)
+
+// Progress reports the bytes written while saving an image.
+type Progress struct {
+	// Layer is the digest of the layer being written (its diff ID when the destination stores uncompressed layers,
+	// e.g. the docker daemon), or empty when the update reports on the whole image.
+	Layer string
+	// Complete is the number of bytes written so far and Total the number of bytes to write, for Layer or for the whole image.
+	// A layer update with no bytes complete marks the start of the layer, and one with all bytes complete marks its end.
+	Complete int64
+	Total    int64
+}
+
+// ProgressFunc receives the progress updates of an image being saved.
+type ProgressFunc func(Progress)
+
+// ProgressTracker reports the progress of writing the layers of an image to a ProgressFunc, for each layer and for the
+// whole image. Updates are serialized, so that the ProgressFunc is never called concurrently.
+// A nil *ProgressTracker reports nothing.
+type ProgressTracker struct {
+	fn       ProgressFunc
+	total    int64
+	complete int64
+	mu       sync.Mutex
+}
+
+// NewProgressTracker returns a ProgressTracker for an image with layers adding up to total bytes,
+// or nil if fn is nil.
+func NewProgressTracker(fn ProgressFunc, total int64) *ProgressTracker {
+	if fn == nil {
+		return nil
+	}
+	return &ProgressTracker{fn: fn, total: total}
+}
+
+// TrackLayer reports the start of the layer, and returns a reader reporting the bytes read from r as the progress of the layer.
+func (t *ProgressTracker) TrackLayer(layer string, size int64, r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	t.report(layer, 0, size, 0)
+	return &progressReader{tracker: t, layer: layer, size: size, r: r}
+}
+
+// SkipLayer reports the layer as complete without writing it, e.g. when it already exists at the destination.
+func (t *ProgressTracker) SkipLayer(layer string, size int64) {
+	if t == nil {
+		return
+	}
+	t.report(layer, size, size, size)
+}
+
+func (t *ProgressTracker) report(layer string, layerComplete, layerSize, written int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.complete += written
+	t.fn(Progress{Layer: layer, Complete: layerComplete, Total: layerSize})
+	t.fn(Progress{Complete: t.complete, Total: t.total})
+}
+
+type progressReader struct {
+	tracker  *ProgressTracker
+	layer    string
+	size     int64
+	complete int64
+	r        io.Reader
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.complete += int64(n)
+		r.tracker.report(r.layer, r.complete, r.size, int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,277 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+var _ imgutil.ImageIndex = (*ImageIndex)(nil)
+
+type ImageIndex struct {
+	keychain         authn.Keychain
+	repoName         string
+	index            v1.ImageIndex
+	registrySettings map[string]registrySetting
+	ctx              context.Context
+}
+
+// NewIndex returns a new ImageIndex that can be modified and saved to a registry.
+func NewIndex(repoName string, keychain authn.Keychain, ops ...IndexOption) (*ImageIndex, error) {
+	indexOpts := &indexOptions{
+		registrySettings: map[string]registrySetting{},
+	}
+	for _, op := range ops {
+		if err := op(indexOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	ri := &ImageIndex{
+		keychain:         keychain,
+		repoName:         repoName,
+		index:            emptyIndex(imgutil.DockerTypes),
+		registrySettings: indexOpts.registrySettings,
+		ctx:              context.Background(),
+	}
+	if indexOpts.ctx != nil {
+		ri.ctx = indexOpts.ctx
+	}
+
+	if indexOpts.baseIndexRepoName != "" {
+		reg := getRegistry(indexOpts.baseIndexRepoName, ri.registrySettings, imgutil.NopLogger{})
+		baseIndex, err := newV1ImageIndex(ri.ctx, keychain, indexOpts.baseIndexRepoName, reg)
+		if err != nil {
+			return nil, err
+		}
+		if baseIndex != nil {
+			ri.index = baseIndex
+		}
+	}
+
+	if mediaType := indexOpts.mediaTypes.IndexType(); mediaType != "" {
+		ri.index = mutate.IndexMediaType(ri.index, mediaType)
+	}
+
+	return ri, nil
+}
+
+func emptyIndex(mediaTypes imgutil.MediaTypes) v1.ImageIndex {
+	return mutate.IndexMediaType(empty.Index, mediaTypes.IndexType())
+}
+
+// newV1ImageIndex returns the index found in the registry by the given name, or nil if it does not exist.
+func newV1ImageIndex(ctx context.Context, keychain authn.Keychain, repoName string, reg registrySetting) (v1.ImageIndex, error) {
+	ref, auth, err := referenceForRepoName(keychain, repoName, reg.insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := remoteOptions(ctx, auth, reg)
+
+	var desc *remote.Descriptor
+	for i := 0; i <= maxRetries; i++ {
+		time.Sleep(100 * time.Duration(i) * time.Millisecond) // wait if retrying
+		desc, err = remote.Get(ref, opts...)
+		if err != nil {
+			if err == io.EOF && i != maxRetries {
+				continue // retry if EOF
+			}
+			if transportErr, ok := err.(*transport.Error); ok && len(transportErr.Errors) > 0 {
+				switch transportErr.StatusCode {
+				case http.StatusNotFound, http.StatusUnauthorized:
+					return nil, nil
+				}
+			}
+			return nil, errors.Wrapf(err, "connect to repo store %q", repoName)
+		}
+		break
+	}
+
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("%q is not an index, found media type %q", repoName, desc.MediaType)
+	}
+
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading index %q", repoName)
+	}
+
+	return index, nil
+}
+
+// getters
+
+func (i *ImageIndex) Annotations() (map[string]string, error) {
+	annotations, err := imgutil.IndexAnnotations(i.index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting annotations for index %q", i.repoName)
+	}
+	return annotations, nil
+}
+
+func (i *ImageIndex) Found() bool {
+	_, err := i.found()
+
+	return err == nil
+}
+
+func (i *ImageIndex) found() (*v1.Descriptor, error) {
+	reg := getRegistry(i.repoName, i.registrySettings, imgutil.NopLogger{})
+	ref, auth, err := referenceForRepoName(i.keychain, i.repoName, reg.insecure)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Head(ref, remoteOptions(i.ctx, auth, reg)...)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("%q is not an index, found media type %q", i.repoName, desc.MediaType)
+	}
+	return desc, nil
+}
+
+func (i *ImageIndex) Identifier() (imgutil.Identifier, error) {
+	ref, err := name.ParseReference(i.repoName, name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing reference for index %q", i.repoName)
+	}
+
+	hash, err := i.index.Digest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting digest for index %q", i.repoName)
+	}
+
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", ref.Context().Name(), hash.String()), name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating digest reference")
+	}
+
+	return DigestIdentifier{
+		Digest: digestRef,
+	}, nil
+}
+
+func (i *ImageIndex) Manifests() ([]v1.Descriptor, error) {
+	manifest, err := i.index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting index manifest for index %q", i.repoName)
+	}
+	return manifest.Manifests, nil
+}
+
+func (i *ImageIndex) MediaType() (types.MediaType, error) {
+	return i.index.MediaType()
+}
+
+func (i *ImageIndex) Name() string {
+	return i.repoName
+}
+
+// setters
+
+func (i *ImageIndex) RemoveAnnotation(key string) error {
+	annotations, err := i.Annotations()
+	if err != nil {
+		return err
+	}
+	delete(annotations, key)
+	i.index = imgutil.WithIndexAnnotations(i.index, annotations)
+	return nil
+}
+
+func (i *ImageIndex) Rename(name string) {
+	i.repoName = name
+}
+
+func (i *ImageIndex) SetAnnotation(key, val string) error {
+	annotations, err := i.Annotations()
+	if err != nil {
+		return err
+	}
+	annotations[key] = val
+	i.index = imgutil.WithIndexAnnotations(i.index, annotations)
+	return nil
+}
+
+// modifiers
+
+func (i *ImageIndex) AddManifest(image v1.Image) error {
+	index, err := imgutil.AppendManifest(i.index, image)
+	if err != nil {
+		return errors.Wrapf(err, "adding manifest to index %q", i.repoName)
+	}
+	i.index = index
+	return nil
+}
+
+func (i *ImageIndex) Delete() error {
+	id, err := i.Identifier()
+	if err != nil {
+		return err
+	}
+	reg := getRegistry(i.repoName, i.registrySettings, imgutil.NopLogger{})
+	ref, auth, err := referenceForRepoName(i.keychain, id.String(), reg.insecure)
+	if err != nil {
+		return err
+	}
+	return remote.Delete(ref, remoteOptions(i.ctx, auth, reg)...)
+}
+
+func (i *ImageIndex) RemoveManifest(digest string) error {
+	index, err := imgutil.RemoveManifest(i.index, digest)
+	if err != nil {
+		return errors.Wrapf(err, "removing manifest from index %q", i.repoName)
+	}
+	i.index = index
+	return nil
+}
+
+func (i *ImageIndex) Save(additionalNames ...string) error {
+	return i.SaveAs(i.Name(), additionalNames...)
+}
+
+func (i *ImageIndex) SaveAs(name string, additionalNames ...string) error {
+	allNames := append([]string{name}, additionalNames...)
+
+	var diagnostics []imgutil.SaveDiagnostic
+	for _, n := range allNames {
+		if err := i.doSave(n); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+		}
+	}
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
+
+func (i *ImageIndex) doSave(indexName string) error {
+	reg := getRegistry(indexName, i.registrySettings, imgutil.NopLogger{})
+	ref, auth, err := referenceForRepoName(i.keychain, indexName, reg.insecure)
+	if err != nil {
+		return err
+	}
+	return remote.WriteIndex(ref, i.index, remoteOptions(i.ctx, auth, reg)...)
+}
+
+// UnderlyingIndex exposes the underlying index for testing
+func (i *ImageIndex) UnderlyingIndex() v1.ImageIndex {
+	return i.index
+}
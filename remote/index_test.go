@@ -0,0 +1,215 @@
+package remote_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sclevine/spec"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func testImageIndex(t *testing.T, when spec.G, it spec.S) {
+	var (
+		repoName string
+		amd64    *remote.Image
+		arm64    *remote.Image
+	)
+
+	newPlatformImage := func(platform imgutil.Platform) *remote.Image {
+		image, err := remote.NewImage(newTestImageName(), authn.DefaultKeychain, remote.WithDefaultPlatform(platform))
+		h.AssertNil(t, err)
+		layerPath, err := h.CreateSingleFileLayerTar("/some-file.txt", platform.Architecture, "linux")
+		h.AssertNil(t, err)
+		defer os.Remove(layerPath)
+		h.AssertNil(t, image.AddLayer(layerPath))
+		h.AssertNil(t, image.Save())
+		return image
+	}
+
+	fetchIndexManifestMediaType := func(repoName string) types.MediaType {
+		ref, err := name.ParseReference(repoName, name.WeakValidation)
+		h.AssertNil(t, err)
+		desc, err := ggcrremote.Get(ref, ggcrremote.WithAuthFromKeychain(authn.DefaultKeychain))
+		h.AssertNil(t, err)
+		return desc.MediaType
+	}
+
+	it.Before(func() {
+		repoName = newTestImageName("pack-index-test")
+		amd64 = newPlatformImage(imgutil.Platform{OS: "linux", Architecture: "amd64"})
+		arm64 = newPlatformImage(imgutil.Platform{OS: "linux", Architecture: "arm64"})
+	})
+
+	when("#NewIndex", func() {
+		when("no base index is given", func() {
+			it("returns an empty Docker manifest list", func() {
+				index, err := remote.NewIndex(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				manifests, err := index.Manifests()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(manifests), 0)
+
+				mediaType, err := index.MediaType()
+				h.AssertNil(t, err)
+				h.AssertEq(t, mediaType, types.DockerManifestList)
+				h.AssertEq(t, index.Found(), false)
+			})
+		})
+
+		when("#FromBaseIndex", func() {
+			it("loads the manifests and annotations of the existing index", func() {
+				base, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.WithIndexMediaTypes(imgutil.OCITypes))
+				h.AssertNil(t, err)
+				h.AssertNil(t, base.AddManifest(amd64.UnderlyingImage()))
+				h.AssertNil(t, base.SetAnnotation("some-key", "some-value"))
+				h.AssertNil(t, base.Save())
+
+				index, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(repoName))
+				h.AssertNil(t, err)
+				h.AssertEq(t, index.Found(), true)
+
+				manifests, err := index.Manifests()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(manifests), 1)
+				h.AssertEq(t, manifests[0].Platform.Architecture, "amd64")
+
+				annotations, err := index.Annotations()
+				h.AssertNil(t, err)
+				h.AssertEq(t, annotations["some-key"], "some-value")
+			})
+
+			it("ignores a missing index", func() {
+				index, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(newTestImageName()))
+				h.AssertNil(t, err)
+
+				manifests, err := index.Manifests()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(manifests), 0)
+			})
+
+			it("returns an error when the base is an image", func() {
+				_, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(amd64.Name()))
+				h.AssertError(t, err, "is not an index")
+			})
+		})
+	})
+
+	when("#AddManifest #RemoveManifest", func() {
+		it("pushes a manifest list with a manifest per platform", func() {
+			index, err := remote.NewIndex(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64.UnderlyingImage()))
+			h.AssertNil(t, index.AddManifest(arm64.UnderlyingImage()))
+			h.AssertNil(t, index.Save())
+
+			h.AssertEq(t, fetchIndexManifestMediaType(repoName), types.DockerManifestList)
+
+			saved, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(repoName))
+			h.AssertNil(t, err)
+			manifests, err := saved.Manifests()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(manifests), 2)
+			h.AssertEq(t, manifests[0].Platform.Architecture, "amd64")
+			h.AssertEq(t, manifests[1].Platform.Architecture, "arm64")
+
+			// platform images are resolvable from the index
+			img, err := remote.NewImage(newTestImageName(), authn.DefaultKeychain,
+				remote.FromBaseImage(repoName),
+				remote.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "arm64"}),
+			)
+			h.AssertNil(t, err)
+			arch, err := img.Architecture()
+			h.AssertNil(t, err)
+			h.AssertEq(t, arch, "arm64")
+
+			digest, err := arm64.UnderlyingImage().Digest()
+			h.AssertNil(t, err)
+			h.AssertNil(t, saved.RemoveManifest(digest.String()))
+			h.AssertNil(t, saved.Save())
+
+			saved, err = remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(repoName))
+			h.AssertNil(t, err)
+			manifests, err = saved.Manifests()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(manifests), 1)
+			h.AssertEq(t, manifests[0].Platform.Architecture, "amd64")
+		})
+	})
+
+	when("#AddManifest", func() {
+		it("carries the manifest annotations on the index descriptor", func() {
+			h.AssertNil(t, amd64.AnnotateRefName("my-tag"))
+			h.AssertNil(t, amd64.Save())
+
+			index, err := remote.NewIndex(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64.UnderlyingImage()))
+			h.AssertNil(t, index.Save())
+
+			saved, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(repoName))
+			h.AssertNil(t, err)
+			manifests, err := saved.Manifests()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(manifests), 1)
+			h.AssertEqAnnotation(t, manifests[0], imgutil.ImageRefNameKey, "my-tag")
+		})
+	})
+
+	when("#SetAnnotation #RemoveAnnotation", func() {
+		it("pushes the index annotations", func() {
+			index, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.WithIndexMediaTypes(imgutil.OCITypes))
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64.UnderlyingImage()))
+			h.AssertNil(t, index.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, index.SetAnnotation("other-key", "other-value"))
+			h.AssertNil(t, index.RemoveAnnotation("other-key"))
+			h.AssertNil(t, index.Save())
+
+			h.AssertEq(t, fetchIndexManifestMediaType(repoName), types.OCIImageIndex)
+
+			saved, err := remote.NewIndex(repoName, authn.DefaultKeychain, remote.FromBaseIndex(repoName))
+			h.AssertNil(t, err)
+			annotations, err := saved.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{"some-key": "some-value"})
+		})
+	})
+
+	when("#Save", func() {
+		when("additional names are provided", func() {
+			it("saves to multiple names", func() {
+				index, err := remote.NewIndex(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, index.AddManifest(amd64.UnderlyingImage()))
+
+				anotherName := newTestImageName("pack-index-test")
+				h.AssertNil(t, index.Save(anotherName))
+
+				h.AssertEq(t, fetchIndexManifestMediaType(repoName), types.DockerManifestList)
+				h.AssertEq(t, fetchIndexManifestMediaType(anotherName), types.DockerManifestList)
+			})
+		})
+	})
+
+	when("#Identifier", func() {
+		it("returns a digest reference", func() {
+			index, err := remote.NewIndex(repoName+":some-tag", authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, index.AddManifest(amd64.UnderlyingImage()))
+
+			identifier, err := index.Identifier()
+			h.AssertNil(t, err)
+			digest, err := index.UnderlyingIndex().Digest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, identifier.String(), repoName+"@"+digest.String())
+		})
+	})
+}
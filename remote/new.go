@@ -1,7 +1,9 @@
 package remote
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -23,7 +25,9 @@ import (
 
 // NewImage returns a new Image that can be modified and saved to a Docker daemon.
 func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (*Image, error) {
-	imageOpts := &options{}
+	imageOpts := &options{
+		registrySettings: map[string]registrySetting{},
+	}
 	for _, op := range ops {
 		if err := op(imageOpts); err != nil {
 			return nil, err
@@ -46,6 +50,18 @@ func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (*Im
 		image:               image,
 		addEmptyLayerOnSave: imageOpts.addEmptyLayerOnSave,
 		registrySettings:    imageOpts.registrySettings,
+		annotateBaseImage:   imageOpts.baseImageAnnotations,
+		ctx:                 imageOpts.ctx,
+		progress:            imageOpts.progress,
+		logger:              imageOpts.logger,
+		withHistory:         imageOpts.history,
+		annotations:         map[string]string{},
+	}
+	if ri.logger == nil {
+		ri.logger = imgutil.NopLogger{}
+	}
+	if ri.ctx == nil {
+		ri.ctx = context.Background()
 	}
 
 	if imageOpts.prevImageRepoName != "" {
@@ -102,6 +118,7 @@ func emptyImage(platform imgutil.Platform) (v1.Image, error) {
 		Architecture: platform.Architecture,
 		OS:           platform.OS,
 		OSVersion:    platform.OSVersion,
+		Variant:      platform.Variant,
 		RootFS: v1.RootFS{
 			Type:    "layers",
 			DiffIDs: []v1.Hash{},
@@ -142,9 +159,9 @@ func prepareNewWindowsImage(ri *Image) error {
 }
 
 func processPreviousImageOption(ri *Image, prevImageRepoName string, platform imgutil.Platform) error {
-	reg := getRegistry(prevImageRepoName, ri.registrySettings)
+	reg := getRegistry(prevImageRepoName, ri.registrySettings, ri.logger)
 
-	prevImage, err := NewV1Image(prevImageRepoName, ri.keychain, WithV1DefaultPlatform(platform), WithV1RegistrySetting(reg.insecure, reg.insecureSkipVerify))
+	prevImage, err := NewV1Image(prevImageRepoName, ri.keychain, WithV1DefaultPlatform(platform), WithV1RegistrySetting(reg.insecure, reg.insecureSkipVerify), WithV1Context(ri.ctx), WithV1Logger(ri.logger))
 	if err != nil {
 		return err
 	}
@@ -159,9 +176,10 @@ func processPreviousImageOption(ri *Image, prevImageRepoName string, platform im
 	return nil
 }
 
-func getRegistry(repoName string, registrySettings map[string]registrySetting) registrySetting {
+func getRegistry(repoName string, registrySettings map[string]registrySetting, logger imgutil.Logger) registrySetting {
 	for prefix, r := range registrySettings {
 		if strings.HasPrefix(repoName, prefix) {
+			logger.Debug("using registry settings", "image", repoName, "repository", prefix, "insecure", r.insecure, "insecureSkipVerify", r.insecureSkipVerify)
 			return r
 		}
 	}
@@ -172,10 +190,31 @@ func getRegistry(repoName string, registrySettings map[string]registrySetting) r
 type v1Options struct {
 	platform        imgutil.Platform
 	registrySetting registrySetting
+	ctx             context.Context
+	logger          imgutil.Logger
+	mustExist       bool
 }
 
 type V1ImageOption func(*v1Options) error
 
+// WithV1Context lets a caller provide the context used to fetch the v1.Image from the registry.
+// Defaults to context.Background().
+func WithV1Context(ctx context.Context) V1ImageOption {
+	return func(opts *v1Options) error {
+		opts.ctx = ctx
+		return nil
+	}
+}
+
+// WithV1Logger lets a caller receive diagnostic messages about fetching the v1.Image, such as retries.
+// Defaults to imgutil.NopLogger.
+func WithV1Logger(logger imgutil.Logger) V1ImageOption {
+	return func(opts *v1Options) error {
+		opts.logger = logger
+		return nil
+	}
+}
+
 // WithV1DefaultPlatform provides Architecture/OS/OSVersion defaults for the new v1.Image.
 func WithV1DefaultPlatform(platform imgutil.Platform) V1ImageOption {
 	return func(opts *v1Options) error {
@@ -195,6 +234,15 @@ func WithV1RegistrySetting(insecure, insecureSkipVerify bool) V1ImageOption {
 	}
 }
 
+// WithV1MustExist makes NewV1Image return an error when the image is not found in the registry,
+// instead of an empty image.
+func WithV1MustExist() V1ImageOption {
+	return func(opts *v1Options) error {
+		opts.mustExist = true
+		return nil
+	}
+}
+
 // NewV1Image returns a new v1.Image
 func NewV1Image(baseImageRepoName string, keychain authn.Keychain, ops ...V1ImageOption) (v1.Image, error) {
 	imageOpts := &v1Options{}
@@ -214,53 +262,93 @@ func NewV1Image(baseImageRepoName string, keychain authn.Keychain, ops ...V1Imag
 		reg = imageOpts.registrySetting
 	}
 
-	baseImage, err := newV1Image(keychain, baseImageRepoName, platform, reg)
+	ctx := context.Background()
+	if imageOpts.ctx != nil {
+		ctx = imageOpts.ctx
+	}
+
+	var logger imgutil.Logger = imgutil.NopLogger{}
+	if imageOpts.logger != nil {
+		logger = imageOpts.logger
+	}
+
+	if imageOpts.mustExist {
+		image, err := fetchV1Image(ctx, logger, keychain, baseImageRepoName, platform, reg)
+		if err != nil {
+			return nil, err
+		}
+		if image == nil {
+			return nil, fmt.Errorf("image %q not found", baseImageRepoName)
+		}
+		return image, nil
+	}
+
+	baseImage, err := newV1Image(ctx, logger, keychain, baseImageRepoName, platform, reg)
 	if err != nil {
 		return nil, err
 	}
 	return baseImage, nil
 }
 
-func newV1Image(keychain authn.Keychain, repoName string, platform imgutil.Platform, reg registrySetting) (v1.Image, error) {
-	ref, auth, err := referenceForRepoName(keychain, repoName, reg.insecure)
+// NewV1Layer returns the layer with the provided digest from the repository of the provided reference, e.g. to fetch
+// the layers missing from a sparse image. The context and registry setting options are used to fetch the layer.
+func NewV1Layer(repoName string, keychain authn.Keychain, digest v1.Hash, ops ...V1ImageOption) (v1.Layer, error) {
+	layerOpts := &v1Options{}
+	for _, op := range ops {
+		if err := op(layerOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := context.Background()
+	if layerOpts.ctx != nil {
+		ctx = layerOpts.ctx
+	}
+
+	ref, auth, err := referenceForRepoName(keychain, repoName, layerOpts.registrySetting.insecure)
 	if err != nil {
 		return nil, err
 	}
+	return remote.Layer(ref.Context().Digest(digest.String()), remoteOptions(ctx, auth, layerOpts.registrySetting)...)
+}
 
-	v1Platform := v1.Platform{
-		Architecture: platform.Architecture,
-		OS:           platform.OS,
-		OSVersion:    platform.OSVersion,
+func newV1Image(ctx context.Context, logger imgutil.Logger, keychain authn.Keychain, repoName string, platform imgutil.Platform, reg registrySetting) (v1.Image, error) {
+	image, err := fetchV1Image(ctx, logger, keychain, repoName, platform, reg)
+	if err != nil {
+		return nil, err
 	}
+	if image == nil {
+		return emptyImage(platform)
+	}
+	return image, nil
+}
 
-	opts := []remote.Option{remote.WithAuth(auth), remote.WithPlatform(v1Platform)}
-	// #nosec G402
-	if reg.insecureSkipVerify {
-		opts = append(opts, remote.WithTransport(&http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}))
-	} else {
-		opts = append(opts, remote.WithTransport(http.DefaultTransport))
+// fetchV1Image returns the image with the provided repo name from the registry, or nil if the image is not found.
+func fetchV1Image(ctx context.Context, logger imgutil.Logger, keychain authn.Keychain, repoName string, platform imgutil.Platform, reg registrySetting) (v1.Image, error) {
+	ref, auth, err := referenceForRepoName(keychain, repoName, reg.insecure)
+	if err != nil {
+		return nil, err
 	}
 
+	opts := remoteOptions(ctx, auth, reg)
+
 	var image v1.Image
 	for i := 0; i <= maxRetries; i++ {
 		time.Sleep(100 * time.Duration(i) * time.Millisecond) // wait if retrying
-		image, err = remote.Image(ref, opts...)
+		image, err = remoteImage(ref, imgutil.V1Platform(platform), opts...)
 		if err != nil {
 			if err == io.EOF && i != maxRetries {
+				logger.Debug("fetching image failed, retrying", "image", repoName, "attempt", i+1, "maxRetries", maxRetries, "error", err)
 				continue // retry if EOF
 			}
 			if transportErr, ok := err.(*transport.Error); ok && len(transportErr.Errors) > 0 {
 				switch transportErr.StatusCode {
 				case http.StatusNotFound, http.StatusUnauthorized:
-					return emptyImage(platform)
+					return nil, nil
 				}
 			}
-			if strings.Contains(err.Error(), "no child with platform") {
-				return emptyImage(platform)
+			if errors.As(err, &imgutil.PlatformNotFoundError{}) {
+				return nil, nil
 			}
 			return nil, errors.Wrapf(err, "connect to repo store %q", repoName)
 		}
@@ -270,6 +358,39 @@ func newV1Image(keychain authn.Keychain, repoName string, platform imgutil.Platf
 	return image, nil
 }
 
+// remoteOptions returns the options to access a registry with the provided authenticator and registry setting
+func remoteOptions(ctx context.Context, auth authn.Authenticator, reg registrySetting) []remote.Option {
+	opts := []remote.Option{remote.WithAuth(auth), remote.WithContext(ctx)}
+	// #nosec G402
+	if reg.insecureSkipVerify {
+		opts = append(opts, remote.WithTransport(&http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}))
+	} else {
+		opts = append(opts, remote.WithTransport(http.DefaultTransport))
+	}
+	return opts
+}
+
+// remoteImage returns the image with the provided reference, or the image matching the provided platform
+// when the reference is an index, see imgutil.ImageFromIndex.
+func remoteImage(ref name.Reference, platform v1.Platform, opts ...remote.Option) (v1.Image, error) {
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		return imgutil.ImageFromIndex(index, platform)
+	}
+	return desc.Image()
+}
+
 func referenceForRepoName(keychain authn.Keychain, ref string, insecure bool) (name.Reference, authn.Authenticator, error) {
 	var auth authn.Authenticator
 	opts := []name.Option{name.WeakValidation}
@@ -289,12 +410,22 @@ func referenceForRepoName(keychain authn.Keychain, ref string, insecure bool) (n
 }
 
 func processBaseImageOption(ri *Image, baseImageRepoName string, platform imgutil.Platform) error {
-	reg := getRegistry(baseImageRepoName, ri.registrySettings)
+	reg := getRegistry(baseImageRepoName, ri.registrySettings, ri.logger)
 
-	baseImage, err := NewV1Image(baseImageRepoName, ri.keychain, WithV1DefaultPlatform(platform), WithV1RegistrySetting(reg.insecure, reg.insecureSkipVerify))
+	baseImage, err := fetchV1Image(ri.ctx, ri.logger, ri.keychain, baseImageRepoName, platform, reg)
 	if err != nil {
 		return err
 	}
+	if baseImage == nil {
+		ri.image, err = emptyImage(platform)
+		return err
+	}
+
+	if ri.annotateBaseImage {
+		if err := imgutil.AnnotateBaseImage(ri.annotations, baseImage, baseImageRepoName); err != nil {
+			return errors.Wrapf(err, "annotating base image %q", baseImageRepoName)
+		}
+	}
 
 	ri.image = baseImage
 
@@ -1,6 +1,7 @@
 package remote
 
 import (
+	"context"
 	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -11,14 +12,19 @@ import (
 type ImageOption func(*options) error
 
 type options struct {
-	platform            imgutil.Platform
-	baseImageRepoName   string
-	prevImageRepoName   string
-	createdAt           time.Time
-	addEmptyLayerOnSave bool
-	registrySettings    map[string]registrySetting
-	mediaTypes          imgutil.MediaTypes
-	config              *v1.Config
+	platform             imgutil.Platform
+	baseImageRepoName    string
+	prevImageRepoName    string
+	createdAt            time.Time
+	addEmptyLayerOnSave  bool
+	registrySettings     map[string]registrySetting
+	mediaTypes           imgutil.MediaTypes
+	config               *v1.Config
+	baseImageAnnotations bool
+	ctx                  context.Context
+	progress             imgutil.ProgressFunc
+	logger               imgutil.Logger
+	history              bool
 }
 
 // AddEmptyLayerOnSave (remote only) adds an empty layer before saving if the image has no layer at all.
@@ -40,6 +46,26 @@ func FromBaseImage(imageName string) ImageOption {
 	}
 }
 
+// WithBaseImageAnnotations records the name and manifest digest of the image loaded with FromBaseImage
+// as the org.opencontainers.image.base.name and org.opencontainers.image.base.digest manifest annotations,
+// and updates them with those of the new base when the image is rebased.
+func WithBaseImageAnnotations() ImageOption {
+	return func(opts *options) error {
+		opts.baseImageAnnotations = true
+		return nil
+	}
+}
+
+// WithContext lets a caller provide the context used by the registry operations of the image,
+// i.e. loading the base and previous images, saving, fetching layers and deleting.
+// Defaults to context.Background().
+func WithContext(ctx context.Context) ImageOption {
+	return func(opts *options) error {
+		opts.ctx = ctx
+		return nil
+	}
+}
+
 // WithCreatedAt lets a caller set the created at timestamp for the image.
 // Defaults for a new image is imgutil.NormalizedDateTime
 func WithCreatedAt(createdAt time.Time) ImageOption {
@@ -66,6 +92,26 @@ func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
 	}
 }
 
+// WithHistory keeps the history of the image when it is saved, i.e. the history of the base image
+// and the entries provided with AddLayerWithDiffIDAndHistory and ReuseLayerWithHistory,
+// with the creation time of every entry set to the created at timestamp of the image.
+// Without it, the history is saved as an empty entry for each layer.
+func WithHistory() ImageOption {
+	return func(opts *options) error {
+		opts.history = true
+		return nil
+	}
+}
+
+// WithLogger lets a caller receive diagnostic messages about the operations of the image,
+// such as retried fetches and the registry settings in use. Defaults to imgutil.NopLogger.
+func WithLogger(logger imgutil.Logger) ImageOption {
+	return func(opts *options) error {
+		opts.logger = logger
+		return nil
+	}
+}
+
 // WithMediaTypes lets a caller set the desired media types for the image manifest and config files,
 // including the layers referenced in the manifest, to be either OCI media types or Docker media types.
 func WithMediaTypes(requested imgutil.MediaTypes) ImageOption {
@@ -75,6 +121,15 @@ func WithMediaTypes(requested imgutil.MediaTypes) ImageOption {
 	}
 }
 
+// WithProgress lets a caller receive the progress of the image pushed to the registry when the image is saved.
+// The registry reports the progress of the whole image only, as layers may be mounted from other repositories rather than uploaded.
+func WithProgress(fn imgutil.ProgressFunc) ImageOption {
+	return func(opts *options) error {
+		opts.progress = fn
+		return nil
+	}
+}
+
 // WithPreviousImage loads an existing image as a source for reusable layers.
 // Use with ReuseLayer().
 // Ignored if image is not found.
@@ -96,3 +151,51 @@ func WithRegistrySetting(repository string, insecure, insecureSkipVerify bool) I
 		return nil
 	}
 }
+
+type IndexOption func(*indexOptions) error
+
+type indexOptions struct {
+	baseIndexRepoName string
+	registrySettings  map[string]registrySetting
+	mediaTypes        imgutil.MediaTypes
+	ctx               context.Context
+}
+
+// FromBaseIndex loads an existing index as the manifests and annotations for the new index.
+// Ignored if index is not found.
+func FromBaseIndex(indexName string) IndexOption {
+	return func(opts *indexOptions) error {
+		opts.baseIndexRepoName = indexName
+		return nil
+	}
+}
+
+// WithIndexContext lets a caller provide the context used by the registry operations of the index.
+// Defaults to context.Background().
+func WithIndexContext(ctx context.Context) IndexOption {
+	return func(opts *indexOptions) error {
+		opts.ctx = ctx
+		return nil
+	}
+}
+
+// WithIndexMediaTypes lets a caller set the desired media type for the index manifest,
+// to be either an OCI image index or a Docker manifest list.
+func WithIndexMediaTypes(requested imgutil.MediaTypes) IndexOption {
+	return func(opts *indexOptions) error {
+		opts.mediaTypes = requested
+		return nil
+	}
+}
+
+// WithIndexRegistrySetting registers options to use when accessing indexes in a registry in order to construct
+// the index. The referenced indexes could include the base index or the index itself.
+func WithIndexRegistrySetting(repository string, insecure, insecureSkipVerify bool) IndexOption {
+	return func(opts *indexOptions) error {
+		opts.registrySettings[repository] = registrySetting{
+			insecure:           insecure,
+			insecureSkipVerify: insecureSkipVerify,
+		}
+		return nil
+	}
+}
@@ -1,10 +1,10 @@
 package remote
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -32,6 +32,12 @@ type Image struct {
 	addEmptyLayerOnSave bool
 	registrySettings    map[string]registrySetting
 	requestedMediaTypes imgutil.MediaTypes
+	annotations         map[string]string // manifest annotations, replacing those of the image on save
+	annotateBaseImage   bool
+	ctx                 context.Context
+	progress            imgutil.ProgressFunc
+	logger              imgutil.Logger
+	withHistory         bool
 }
 
 type registrySetting struct {
@@ -41,6 +47,14 @@ type registrySetting struct {
 
 // getters
 
+func (i *Image) Annotations() (map[string]string, error) {
+	annotations := make(map[string]string, len(i.annotations))
+	for k, v := range i.annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
 func (i *Image) Architecture() (string, error) {
 	cfg, err := i.image.ConfigFile()
 	if err != nil {
@@ -55,6 +69,17 @@ func (i *Image) Architecture() (string, error) {
 	return cfg.Architecture, nil
 }
 
+func (i *Image) Cmd() ([]string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.Cmd, nil
+}
+
 func (i *Image) CreatedAt() (time.Time, error) {
 	configFile, err := i.image.ConfigFile()
 	if err != nil {
@@ -82,13 +107,30 @@ func (i *Image) Env(key string) (string, error) {
 	if cfg == nil {
 		return "", fmt.Errorf("missing config for image %q", i.repoName)
 	}
-	for _, envVar := range cfg.Config.Env {
-		parts := strings.Split(envVar, "=")
-		if parts[0] == key {
-			return parts[1], nil
-		}
+	val, _ := imgutil.EnvValue(cfg.Config.Env, cfg.OS, key)
+	return val, nil
+}
+
+func (i *Image) Envs() ([]imgutil.EnvVar, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
 	}
-	return "", nil
+	return imgutil.EnvVars(cfg.Config.Env), nil
+}
+
+func (i *Image) ExposedPorts() (map[string]struct{}, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.ExposedPorts, nil
 }
 
 func (i *Image) Found() bool {
@@ -98,12 +140,12 @@ func (i *Image) Found() bool {
 }
 
 func (i *Image) found() (*v1.Descriptor, error) {
-	reg := getRegistry(i.repoName, i.registrySettings)
+	reg := getRegistry(i.repoName, i.registrySettings, i.logger)
 	ref, auth, err := referenceForRepoName(i.keychain, i.repoName, reg.insecure)
 	if err != nil {
 		return nil, err
 	}
-	return remote.Head(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport))
+	return remote.Head(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport), remote.WithContext(i.ctx))
 }
 
 func (i *Image) Valid() bool {
@@ -111,12 +153,12 @@ func (i *Image) Valid() bool {
 }
 
 func (i *Image) valid() error {
-	reg := getRegistry(i.repoName, i.registrySettings)
+	reg := getRegistry(i.repoName, i.registrySettings, i.logger)
 	ref, auth, err := referenceForRepoName(i.keychain, i.repoName, reg.insecure)
 	if err != nil {
 		return err
 	}
-	desc, err := remote.Get(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport))
+	desc, err := remote.Get(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport), remote.WithContext(i.ctx))
 	if err != nil {
 		return err
 	}
@@ -136,8 +178,7 @@ func (i *Image) valid() error {
 }
 
 func (i *Image) GetAnnotateRefName() (string, error) {
-	// TODO issue https://github.com/buildpacks/imgutil/issues/178
-	return "", errors.New("not yet implemented")
+	return i.annotations[imgutil.ImageRefNameKey], nil
 }
 
 func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
@@ -154,6 +195,28 @@ func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
 	return layer.Uncompressed()
 }
 
+func (i *Image) Healthcheck() (*v1.HealthConfig, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.Healthcheck, nil
+}
+
+func (i *Image) History() ([]v1.History, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.History, nil
+}
+
 func (i *Image) Identifier() (imgutil.Identifier, error) {
 	ref, err := name.ParseReference(i.repoName, name.WeakValidation)
 	if err != nil {
@@ -198,6 +261,14 @@ func (i *Image) Labels() (map[string]string, error) {
 	return cfg.Config.Labels, nil
 }
 
+func (i *Image) LayerDescriptors() ([]imgutil.LayerDescriptor, error) {
+	descriptors, err := imgutil.LayerDescriptors(i.image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting layer descriptors for image %q", i.repoName)
+	}
+	return descriptors, nil
+}
+
 func (i *Image) ManifestSize() (int64, error) {
 	return i.image.Size()
 }
@@ -231,6 +302,28 @@ func (i *Image) OSVersion() (string, error) {
 	return cfg.OSVersion, nil
 }
 
+func (i *Image) Shell() ([]string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.Shell, nil
+}
+
+func (i *Image) StopSignal() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return "", errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return "", fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.StopSignal, nil
+}
+
 func (i *Image) TopLayer() (string, error) {
 	all, err := i.image.Layers()
 	if err != nil {
@@ -247,6 +340,17 @@ func (i *Image) TopLayer() (string, error) {
 	return hex.String(), nil
 }
 
+func (i *Image) User() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return "", errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return "", fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.User, nil
+}
+
 func (i *Image) Variant() (string, error) {
 	cfg, err := i.image.ConfigFile()
 	if err != nil {
@@ -258,6 +362,17 @@ func (i *Image) Variant() (string, error) {
 	return cfg.Variant, nil // it's optional so we don't care whether it's ""
 }
 
+func (i *Image) Volumes() (map[string]struct{}, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting config file for image %q", i.repoName)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("missing config for image %q", i.repoName)
+	}
+	return cfg.Config.Volumes, nil
+}
+
 func (i *Image) WorkingDir() (string, error) {
 	cfg, err := i.image.ConfigFile()
 	if err != nil {
@@ -271,15 +386,33 @@ func (i *Image) WorkingDir() (string, error) {
 
 // setters
 
+// AnnotateRefName sets the `org.opencontainers.image.ref.name` annotation on the image manifest when it is saved.
 func (i *Image) AnnotateRefName(refName string) error {
-	// TODO issue https://github.com/buildpacks/imgutil/issues/178
-	return errors.New("not yet implemented")
+	i.annotations[imgutil.ImageRefNameKey] = refName
+	return nil
+}
+
+func (i *Image) AppendEnv(key, val string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.AppendEnv(env, os, key, val)
+	})
+}
+
+func (i *Image) PrependEnv(key, val string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.PrependEnv(env, os, key, val)
+	})
 }
 
 func (i *Image) Rename(name string) {
 	i.repoName = name
 }
 
+func (i *Image) SetAnnotation(key, val string) error {
+	i.annotations[key] = val
+	return nil
+}
+
 func (i *Image) SetArchitecture(architecture string) error {
 	configFile, err := i.image.ConfigFile()
 	if err != nil {
@@ -313,31 +446,56 @@ func (i *Image) SetEntrypoint(ep ...string) error {
 }
 
 func (i *Image) SetEnv(key, val string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.SetEnv(env, os, key, val)
+	})
+}
+
+// updateEnv replaces the environment variables of the image with the result of the provided function
+func (i *Image) updateEnv(update func(env []string, os string) []string) error {
 	configFile, err := i.image.ConfigFile()
 	if err != nil {
 		return err
 	}
 	config := *configFile.Config.DeepCopy()
-	ignoreCase := configFile.OS == "windows"
-	for idx, e := range config.Env {
-		parts := strings.Split(e, "=")
-		foundKey := parts[0]
-		searchKey := key
-		if ignoreCase {
-			foundKey = strings.ToUpper(foundKey)
-			searchKey = strings.ToUpper(searchKey)
-		}
-		if foundKey == searchKey {
-			config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
-			i.image, err = mutate.Config(i.image, config)
-			return err
-		}
+	config.Env = update(config.Env, configFile.OS)
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetExposedPorts(ports map[string]struct{}) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
 	}
-	config.Env = append(config.Env, fmt.Sprintf("%s=%s", key, val))
+	config := *configFile.Config.DeepCopy()
+	config.ExposedPorts = ports
 	i.image, err = mutate.Config(i.image, config)
 	return err
 }
 
+func (i *Image) SetHealthcheck(healthcheck *v1.HealthConfig) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Healthcheck = healthcheck
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetHistory(history []v1.History) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	configFile = configFile.DeepCopy()
+	configFile.History = history
+	i.image, err = mutate.ConfigFile(i.image, configFile)
+	return err
+}
+
 func (i *Image) SetLabel(key, val string) error {
 	configFile, err := i.image.ConfigFile()
 	if err != nil {
@@ -372,6 +530,39 @@ func (i *Image) SetOSVersion(osVersion string) error {
 	return err
 }
 
+func (i *Image) SetShell(shell ...string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Shell = shell
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetStopSignal(signal string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.StopSignal = signal
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetUser(user string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.User = user
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
 func (i *Image) SetVariant(variant string) error {
 	configFile, err := i.image.ConfigFile()
 	if err != nil {
@@ -382,6 +573,17 @@ func (i *Image) SetVariant(variant string) error {
 	return err
 }
 
+func (i *Image) SetVolumes(volumes map[string]struct{}) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Volumes = volumes
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
 func (i *Image) SetWorkingDir(dir string) error {
 	configFile, err := i.image.ConfigFile()
 	if err != nil {
@@ -396,11 +598,17 @@ func (i *Image) SetWorkingDir(dir string) error {
 // modifiers
 
 func (i *Image) AddLayer(path string) error {
+	return i.addLayer(path, v1.History{})
+}
+
+// addLayer appends the layer at the provided path with the desired media type and the provided history entry
+func (i *Image) addLayer(path string, history v1.History) error {
 	layer, err := tarball.LayerFromFile(path)
 	if err != nil {
 		return err
 	}
 	additions := layersAddendum([]v1.Layer{layer}, i.requestedMediaTypes.LayerType())
+	additions[0].History = history
 	i.image, err = mutate.Append(i.image, additions...)
 	if err != nil {
 		return errors.Wrap(err, "add layer")
@@ -427,53 +635,66 @@ func (i *Image) AddLayerWithDiffID(path, diffID string) error {
 	return i.AddLayer(path)
 }
 
+func (i *Image) AddLayerWithDiffIDAndHistory(path, diffID string, history v1.History) error {
+	// this is equivalent to AddLayer in the remote case, see AddLayerWithDiffID
+	return i.addLayer(path, history)
+}
+
+// Close does nothing, as a remote image does not create temporary files.
+func (i *Image) Close() error {
+	return nil
+}
+
 func (i *Image) Delete() error {
 	id, err := i.Identifier()
 	if err != nil {
 		return err
 	}
-	reg := getRegistry(i.repoName, i.registrySettings)
+	reg := getRegistry(i.repoName, i.registrySettings, i.logger)
 	ref, auth, err := referenceForRepoName(i.keychain, id.String(), reg.insecure)
 	if err != nil {
 		return err
 	}
-	return remote.Delete(ref, remote.WithAuth(auth))
+	return remote.Delete(ref, remote.WithAuth(auth), remote.WithContext(i.ctx))
 }
 
+// Rebase replaces the layers of the image up to and including baseTopLayer with the layers of newBase,
+// and adopts the OS, architecture and OS version of newBase.
+// newBase can be an image from any backend exposing its config and layers, see imgutil.V1Image.
 func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
-	newBaseRemote, ok := newBase.(*Image)
-	if !ok {
-		return errors.New("expected new base to be a remote image")
-	}
-
-	newImage, err := mutate.Rebase(i.image, &subImage{img: i.image, topDiffID: baseTopLayer}, newBaseRemote.image)
-	if err != nil {
-		return errors.Wrap(err, "rebase")
-	}
-
-	newImageConfig, err := newImage.ConfigFile()
+	newBaseImage, err := imgutil.V1Image(newBase)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "reading new base")
 	}
 
-	newBaseRemoteConfig, err := newBaseRemote.image.ConfigFile()
+	newImage, err := imgutil.RebaseV1Image(i.image, baseTopLayer, newBaseImage)
 	if err != nil {
 		return err
 	}
 
-	newImageConfig.Architecture = newBaseRemoteConfig.Architecture
-	newImageConfig.OS = newBaseRemoteConfig.OS
-	newImageConfig.OSVersion = newBaseRemoteConfig.OSVersion
+	i.image = newImage
 
-	newImage, err = mutate.ConfigFile(newImage, newImageConfig)
-	if err != nil {
-		return err
+	if i.annotateBaseImage {
+		digest, err := imgutil.ManifestDigest(newBase)
+		if err != nil {
+			return errors.Wrapf(err, "getting digest for new base image %q", newBase.Name())
+		}
+		imgutil.SetBaseImageAnnotations(i.annotations, newBase.Name(), digest)
 	}
+	return nil
+}
 
-	i.image = newImage
+func (i *Image) RemoveAnnotation(key string) error {
+	delete(i.annotations, key)
 	return nil
 }
 
+func (i *Image) RemoveEnv(key string) error {
+	return i.updateEnv(func(env []string, os string) []string {
+		return imgutil.RemoveEnv(env, os, key)
+	})
+}
+
 func (i *Image) RemoveLabel(key string) error {
 	cfg, err := i.image.ConfigFile()
 	if err != nil {
@@ -488,12 +709,38 @@ func (i *Image) RemoveLabel(key string) error {
 	return err
 }
 
+func (i *Image) RemoveLayer(diffID string) error {
+	image, err := imgutil.RemoveLayer(i.image, diffID)
+	if err != nil {
+		return errors.Wrapf(err, "removing layer from image %q", i.repoName)
+	}
+	i.image = image
+	return nil
+}
+
+func (i *Image) ReplaceLayer(diffID, path string) error {
+	layer, err := tarball.LayerFromFile(path)
+	if err != nil {
+		return err
+	}
+	image, err := imgutil.ReplaceLayer(i.image, diffID, layer, i.requestedMediaTypes.LayerType())
+	if err != nil {
+		return errors.Wrapf(err, "replacing layer of image %q", i.repoName)
+	}
+	i.image = image
+	return nil
+}
+
 func (i *Image) ReuseLayer(sha string) error {
+	return i.ReuseLayerWithHistory(sha, v1.History{})
+}
+
+func (i *Image) ReuseLayerWithHistory(sha string, history v1.History) error {
 	layer, err := findLayerWithSha(i.prevLayers, sha)
 	if err != nil {
 		return err
 	}
-	i.image, err = mutate.AppendLayers(i.image, layer)
+	i.image, err = mutate.Append(i.image, mutate.Addendum{Layer: layer, History: history})
 	return err
 }
 
@@ -512,7 +759,7 @@ func (i *Image) CheckReadAccess() bool {
 }
 
 func (i *Image) CheckReadWriteAccess() bool {
-	reg := getRegistry(i.repoName, i.registrySettings)
+	reg := getRegistry(i.repoName, i.registrySettings, i.logger)
 	ref, _, err := referenceForRepoName(i.keychain, i.repoName, reg.insecure)
 	if err != nil {
 		return false
@@ -520,7 +767,21 @@ func (i *Image) CheckReadWriteAccess() bool {
 	return i.CheckReadAccess() && remote.CheckPushPermission(ref, i.keychain, http.DefaultTransport) == nil
 }
 
-// UnderlyingImage exposes the underlying image for testing
+// SetUnderlyingImage replaces the config and layers of the image with those of the provided image, along with its
+// manifest annotations, e.g. to push an image from another backend to the registry, see imgutil.Copy.
+func (i *Image) SetUnderlyingImage(image v1.Image) error {
+	annotations, err := imgutil.ManifestAnnotations(image)
+	if err != nil {
+		return errors.Wrap(err, "reading manifest annotations")
+	}
+	if err := i.setUnderlyingImage(image); err != nil {
+		return err
+	}
+	i.annotations = annotations
+	return nil
+}
+
+// UnderlyingImage exposes the underlying image, e.g. to use it as the new base of an image from another backend
 func (i *Image) UnderlyingImage() v1.Image {
 	return i.image
 }
@@ -539,38 +800,3 @@ func findLayerWithSha(layers []v1.Layer, diffID string) (v1.Layer, error) {
 	}
 	return nil, fmt.Errorf("previous image did not have layer with diff id %q", diffID)
 }
-
-// for rebase
-
-type subImage struct {
-	img       v1.Image
-	topDiffID string
-}
-
-func (si *subImage) Layers() ([]v1.Layer, error) {
-	all, err := si.img.Layers()
-	if err != nil {
-		return nil, err
-	}
-	for i, l := range all {
-		d, err := l.DiffID()
-		if err != nil {
-			return nil, err
-		}
-		if d.String() == si.topDiffID {
-			return all[0 : i+1], nil
-		}
-	}
-	return nil, errors.New("could not find base layer in image")
-}
-func (si *subImage) ConfigFile() (*v1.ConfigFile, error)     { return si.img.ConfigFile() }
-func (si *subImage) BlobSet() (map[v1.Hash]struct{}, error)  { panic("Not Implemented") }
-func (si *subImage) MediaType() (types.MediaType, error)     { panic("Not Implemented") }
-func (si *subImage) ConfigName() (v1.Hash, error)            { panic("Not Implemented") }
-func (si *subImage) RawConfigFile() ([]byte, error)          { panic("Not Implemented") }
-func (si *subImage) Digest() (v1.Hash, error)                { panic("Not Implemented") }
-func (si *subImage) Manifest() (*v1.Manifest, error)         { panic("Not Implemented") }
-func (si *subImage) RawManifest() ([]byte, error)            { panic("Not Implemented") }
-func (si *subImage) LayerByDigest(v1.Hash) (v1.Layer, error) { panic("Not Implemented") }
-func (si *subImage) LayerByDiffID(v1.Hash) (v1.Layer, error) { panic("Not Implemented") }
-func (si *subImage) Size() (int64, error)                    { panic("Not Implemented") }
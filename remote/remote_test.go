@@ -1,11 +1,17 @@
 package remote_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,10 +19,15 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
 	"github.com/buildpacks/imgutil/remote"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
@@ -72,6 +83,7 @@ func TestRemote(t *testing.T) {
 	defer os.Unsetenv("DOCKER_CONFIG")
 
 	spec.Run(t, "Image", testImage, spec.Sequential(), spec.Report(report.Terminal{}))
+	spec.Run(t, "ImageIndex", testImageIndex, spec.Sequential(), spec.Report(report.Terminal{}))
 }
 
 func testImage(t *testing.T, when spec.G, it spec.S) {
@@ -369,6 +381,62 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 						})
 					})
 
+					when("the index lists the platform of its manifests", func() {
+						it("reads only the manifest matching the platform", func() {
+							var requested []string
+							var mu sync.Mutex
+							handler := registry.New(registry.Logger(log.New(ioutil.Discard, "", log.Lshortfile)))
+							server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+								mu.Lock()
+								requested = append(requested, r.Method+" "+r.URL.Path)
+								mu.Unlock()
+								handler.ServeHTTP(w, r)
+							}))
+							defer server.Close()
+							indexName := strings.TrimPrefix(server.URL, "http://") + "/platforms:latest"
+
+							platformImage := func(arch string) v1.Image {
+								image, err := random.Image(1024, 1)
+								h.AssertNil(t, err)
+								configFile, err := image.ConfigFile()
+								h.AssertNil(t, err)
+								configFile = configFile.DeepCopy()
+								configFile.OS, configFile.Architecture = "linux", arch
+								image, err = mutate.ConfigFile(image, configFile)
+								h.AssertNil(t, err)
+								return image
+							}
+							armImage, amdImage := platformImage("arm64"), platformImage("amd64")
+							index := mutate.AppendManifests(empty.Index,
+								mutate.IndexAddendum{Add: armImage, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+								mutate.IndexAddendum{Add: amdImage, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+							)
+							ref, err := name.ParseReference(indexName)
+							h.AssertNil(t, err)
+							h.AssertNil(t, ggcrremote.WriteIndex(ref, index))
+							armDigest, err := armImage.Digest()
+							h.AssertNil(t, err)
+							amdDigest, err := amdImage.Digest()
+							h.AssertNil(t, err)
+							requested = nil
+
+							img, err := remote.NewImage(
+								repoName,
+								authn.DefaultKeychain,
+								remote.FromBaseImage(indexName),
+								remote.WithDefaultPlatform(imgutil.Platform{OS: "linux", Architecture: "amd64"}),
+							)
+							h.AssertNil(t, err)
+
+							digest, err := img.UnderlyingImage().Digest()
+							h.AssertNil(t, err)
+							h.AssertEq(t, digest, amdDigest)
+							for _, request := range requested {
+								h.AssertEq(t, strings.Contains(request, armDigest.String()), false)
+							}
+						})
+					})
+
 					when("no image with matching platform exists", func() {
 						it("returns an empty image with platform fields set", func() {
 							manifestListName := "golang:1.13.8"
@@ -592,6 +660,69 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("#WithContext", func() {
+			it("stops loading the base image once the context is cancelled", func() {
+				baseImageName := newTestImageName()
+				baseImage, err := remote.NewImage(baseImageName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, baseImage.Save())
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				_, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName), remote.WithContext(ctx))
+				h.AssertError(t, err, "context canceled")
+			})
+
+			it("stops saving the image once the context is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithContext(ctx))
+				h.AssertNil(t, err)
+
+				cancel()
+
+				h.AssertError(t, img.Save(), "context canceled")
+
+				savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertEq(t, savedImg.Found(), false)
+			})
+		})
+
+		when("#WithLogger", func() {
+			it("logs the registry settings used to access the image", func() {
+				logger := &h.Logger{}
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithRegistrySetting(repoName, false, false), remote.WithLogger(logger))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+
+				h.AssertContains(t, logger.Messages(), "using registry settings")
+			})
+		})
+
+		when("#WithProgress", func() {
+			it("reports the progress of the image pushed to the registry", func() {
+				var updates []imgutil.Progress
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithProgress(func(p imgutil.Progress) {
+					updates = append(updates, p)
+				}))
+				h.AssertNil(t, err)
+				layerPath, err := h.CreateSingleFileLayerTar("/progress.txt", "some-content", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(layerPath)
+				h.AssertNil(t, img.AddLayer(layerPath))
+
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, len(updates) > 0, true)
+				last := updates[len(updates)-1]
+				h.AssertEq(t, last.Layer, "")
+				h.AssertEq(t, last.Complete, last.Total)
+				h.AssertEq(t, last.Total > 0, true)
+			})
+		})
 	})
 
 	when("#WorkingDir", func() {
@@ -798,6 +929,7 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				baseImage, err := remote.NewImage(baseImageName, authn.DefaultKeychain)
 				h.AssertNil(t, err)
 				h.AssertNil(t, baseImage.SetEnv("MY_VAR", "my_val"))
+				h.AssertNil(t, baseImage.SetEnv("JAVA_OPTS", "-Dx=y"))
 				h.AssertNil(t, baseImage.Save())
 			})
 
@@ -810,6 +942,15 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, val, "my_val")
 			})
 
+			it("returns values containing =", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
+				h.AssertNil(t, err)
+
+				val, err := img.Env("JAVA_OPTS")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "-Dx=y")
+			})
+
 			it("returns an empty string for a missing label", func() {
 				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseImageName))
 				h.AssertNil(t, err)
@@ -1064,6 +1205,53 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Envs #RemoveEnv #AppendEnv #PrependEnv", func() {
+		it("manages the environment in order", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetEnv("PATH", "/usr/bin"))
+			h.AssertNil(t, img.SetEnv("JAVA_OPTS", "-Dx=y"))
+			h.AssertNil(t, img.SetEnv("REMOVED", "some-val"))
+			h.AssertNil(t, img.AppendEnv("PATH", "/app/bin"))
+			h.AssertNil(t, img.PrependEnv("PATH", "/layers/bin"))
+			h.AssertNil(t, img.AppendEnv("NEW_PATH", "/some/path"))
+			h.AssertNil(t, img.RemoveEnv("REMOVED"))
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.Env, []string{"PATH=/layers/bin:/usr/bin:/app/bin", "JAVA_OPTS=-Dx=y", "NEW_PATH=/some/path"})
+
+			envs, err := img.Envs()
+			h.AssertNil(t, err)
+			h.AssertEq(t, envs, []imgutil.EnvVar{
+				{Key: "PATH", Value: "/layers/bin:/usr/bin:/app/bin"},
+				{Key: "JAVA_OPTS", Value: "-Dx=y"},
+				{Key: "NEW_PATH", Value: "/some/path"},
+			})
+		})
+
+		when("windows", func() {
+			it("ignores case and uses the windows list separator", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithDefaultPlatform(imgutil.Platform{OS: "windows", Architecture: "amd64"}))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.SetEnv("Path", `C:\Windows`))
+				h.AssertNil(t, img.AppendEnv("PATH", `C:\app`))
+				h.AssertNil(t, img.SetEnv("REMOVED", "some-val"))
+				h.AssertNil(t, img.RemoveEnv("removed"))
+
+				val, err := img.Env("path")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, `C:\Windows;C:\app`)
+				envs, err := img.Envs()
+				h.AssertNil(t, err)
+				h.AssertEq(t, envs, []imgutil.EnvVar{{Key: "PATH", Value: `C:\Windows;C:\app`}})
+			})
+		})
+	})
+
 	when("#SetWorkingDir", func() {
 		it("sets the environment", func() {
 			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
@@ -1109,6 +1297,43 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetUser #SetExposedPorts #SetVolumes #SetStopSignal #SetShell #SetHealthcheck", func() {
+		it("sets the runtime config", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			healthcheck := &v1.HealthConfig{Test: []string{"CMD", "some-check"}, Interval: time.Second, Retries: 3}
+			h.AssertNil(t, img.SetUser("some-user"))
+			h.AssertNil(t, img.SetExposedPorts(map[string]struct{}{"8080/tcp": {}}))
+			h.AssertNil(t, img.SetVolumes(map[string]struct{}{"/some/volume": {}}))
+			h.AssertNil(t, img.SetStopSignal("SIGKILL"))
+			h.AssertNil(t, img.SetShell("/bin/bash", "-c"))
+			h.AssertNil(t, img.SetHealthcheck(healthcheck))
+
+			h.AssertNil(t, img.Save())
+
+			configFile := h.FetchManifestImageConfigFile(t, repoName)
+			h.AssertEq(t, configFile.Config.User, "some-user")
+			h.AssertEq(t, configFile.Config.ExposedPorts, map[string]struct{}{"8080/tcp": {}})
+			h.AssertEq(t, configFile.Config.Volumes, map[string]struct{}{"/some/volume": {}})
+			h.AssertEq(t, configFile.Config.StopSignal, "SIGKILL")
+			h.AssertEq(t, configFile.Config.Shell, []string{"/bin/bash", "-c"})
+			h.AssertEq(t, configFile.Config.Healthcheck, healthcheck)
+
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			user, err := savedImg.User()
+			h.AssertNil(t, err)
+			h.AssertEq(t, user, "some-user")
+			ports, err := savedImg.ExposedPorts()
+			h.AssertNil(t, err)
+			h.AssertEq(t, ports, map[string]struct{}{"8080/tcp": {}})
+			savedHealthcheck, err := savedImg.Healthcheck()
+			h.AssertNil(t, err)
+			h.AssertEq(t, savedHealthcheck, healthcheck)
+		})
+	})
+
 	when("#SetOS #SetOSVersion #SetArchitecture", func() {
 		it("sets the os/arch", func() {
 			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
@@ -1130,6 +1355,57 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetAnnotation #RemoveAnnotation", func() {
+		it("saves the annotations on the manifest", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.source", "https://example.com/some-repo"))
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.revision", "some-revision"))
+			h.AssertNil(t, img.RemoveAnnotation("org.opencontainers.image.revision"))
+
+			h.AssertNil(t, img.Save())
+
+			manifest := h.FetchManifest(t, repoName)
+			h.AssertEq(t, manifest.Annotations, map[string]string{"org.opencontainers.image.source": "https://example.com/some-repo"})
+		})
+
+		it("does not inherit the annotations of the base image", func() {
+			existing, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, existing.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, existing.Save())
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			annotations, err := img.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{})
+
+			h.AssertNil(t, img.Save())
+
+			manifest := h.FetchManifest(t, repoName)
+			h.AssertEq(t, len(manifest.Annotations), 0)
+		})
+	})
+
+	when("#AnnotateRefName", func() {
+		it("sets the ref name annotation on the manifest", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.AnnotateRefName("my-tag"))
+			refName, err := img.GetAnnotateRefName()
+			h.AssertNil(t, err)
+			h.AssertEq(t, refName, "my-tag")
+
+			h.AssertNil(t, img.Save())
+
+			manifest := h.FetchManifest(t, repoName)
+			h.AssertEq(t, manifest.Annotations[imgutil.ImageRefNameKey], "my-tag")
+		})
+	})
+
 	when("#Rebase", func() {
 		when("image exists", func() {
 			var oldBase, newBase, oldTopLayerDiffID string
@@ -1237,6 +1513,105 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, rebasedImgConfig.OSVersion, newBaseConfig.OSVersion)
 				h.AssertEq(t, rebasedImgConfig.Architecture, newBaseConfig.Architecture)
 			})
+
+			when("the new base is a layout image", func() {
+				it("switches the base", func() {
+					tmpDir, err := ioutil.TempDir("", "remote-rebase-layout")
+					h.AssertNil(t, err)
+					defer os.RemoveAll(tmpDir)
+
+					newBaseImg, err := layout.NewImage(filepath.Join(tmpDir, "new-base"), layout.WithDefaultPlatform(imgutil.Platform{
+						OS:           "linux",
+						Architecture: "arm64",
+					}))
+					h.AssertNil(t, err)
+					newBaseLayerPath, err := h.CreateSingleFileLayerTar("/new-base.txt", "new-base-from-layout", "linux")
+					h.AssertNil(t, err)
+					defer os.Remove(newBaseLayerPath)
+					h.AssertNil(t, newBaseImg.AddLayer(newBaseLayerPath))
+					h.AssertNil(t, newBaseImg.Save())
+
+					img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.Rebase(oldTopLayerDiffID, newBaseImg))
+					h.AssertNil(t, img.Save())
+
+					h.AssertEq(t,
+						h.FetchManifestLayers(t, repoName),
+						append([]string{h.FileDiffID(t, newBaseLayerPath)}, repoTopLayers...),
+					)
+
+					rebasedImgConfig := h.FetchManifestImageConfigFile(t, repoName)
+					h.AssertEq(t, rebasedImgConfig.OS, "linux")
+					h.AssertEq(t, rebasedImgConfig.Architecture, "arm64")
+				})
+			})
+
+			when("#WithBaseImageAnnotations", func() {
+				it("records the base image and updates it with the new base", func() {
+					oldBaseImg, err := remote.NewImage(oldBase, authn.DefaultKeychain, remote.FromBaseImage(oldBase))
+					h.AssertNil(t, err)
+					oldBaseID, err := oldBaseImg.Identifier()
+					h.AssertNil(t, err)
+
+					img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(oldBase), remote.WithBaseImageAnnotations())
+					h.AssertNil(t, err)
+					annotations, err := img.Annotations()
+					h.AssertNil(t, err)
+					h.AssertEq(t, annotations[imgutil.BaseImageNameKey], oldBase)
+					h.AssertEq(t, annotations[imgutil.BaseImageDigestKey], oldBaseID.(remote.DigestIdentifier).Digest.DigestStr())
+
+					newBaseImg, err := remote.NewImage(newBase, authn.DefaultKeychain, remote.FromBaseImage(newBase))
+					h.AssertNil(t, err)
+					newBaseID, err := newBaseImg.Identifier()
+					h.AssertNil(t, err)
+
+					h.AssertNil(t, img.Rebase(oldTopLayerDiffID, newBaseImg))
+					h.AssertNil(t, img.Save())
+
+					manifest := h.FetchManifest(t, repoName)
+					h.AssertEq(t, manifest.Annotations[imgutil.BaseImageNameKey], newBase)
+					h.AssertEq(t, manifest.Annotations[imgutil.BaseImageDigestKey], newBaseID.(remote.DigestIdentifier).Digest.DigestStr())
+				})
+
+				it("does not record the base image by default", func() {
+					img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(oldBase))
+					h.AssertNil(t, err)
+					annotations, err := img.Annotations()
+					h.AssertNil(t, err)
+					_, ok := annotations[imgutil.BaseImageNameKey]
+					h.AssertEq(t, ok, false)
+				})
+			})
+		})
+	})
+
+	when("#Copy", func() {
+		it("pushes the config, layers and annotations of an image from another backend", func() {
+			tmpDir, err := ioutil.TempDir("", "remote-copy-layout")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(tmpDir)
+
+			srcPath := filepath.Join(tmpDir, "src")
+			srcImg, err := layout.NewImage(srcPath)
+			h.AssertNil(t, err)
+			layerPath, diffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, srcImg.AddLayer(layerPath))
+			h.AssertNil(t, srcImg.SetLabel("some-label", "some-value"))
+			h.AssertNil(t, srcImg.SetAnnotation("saved-annotation", "some-value"))
+			h.AssertNil(t, srcImg.Save())
+
+			src, err := layout.NewImage(srcPath, layout.FromBaseImagePath(srcPath))
+			h.AssertNil(t, err)
+			h.AssertNil(t, src.SetAnnotation("some-annotation", "some-value"))
+			dst, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, imgutil.Copy(src, dst))
+
+			h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{diffID})
+			h.AssertEq(t, h.FetchManifestImageConfigFile(t, repoName).Config.Labels["some-label"], "some-value")
+			// the annotations of the base manifest are not those of src
+			h.AssertEq(t, h.FetchManifest(t, repoName).Annotations, map[string]string{"some-annotation": "some-value"})
 		})
 	})
 
@@ -1369,6 +1744,96 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#AddLayerWithDiffIDAndHistory", func() {
+		it("appends a layer with its history", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithHistory())
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			layerDiffID := h.FileDiffID(t, layerPath)
+
+			h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(layerPath, layerDiffID, v1.History{CreatedBy: "some-buildpack", Comment: "some-comment", Author: "some-author"}))
+
+			h.AssertNil(t, img.Save())
+
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			history, err := savedImg.History()
+			h.AssertNil(t, err)
+			h.AssertEq(t, history, []v1.History{{
+				Created:   v1.Time{Time: imgutil.NormalizedDateTime},
+				CreatedBy: "some-buildpack",
+				Comment:   "some-comment",
+				Author:    "some-author",
+			}})
+		})
+	})
+
+	when("#LayerDescriptors #RemoveLayer #ReplaceLayer", func() {
+		var (
+			img                       *remote.Image
+			tmpDir                    string
+			diffID1, diffID2, diffID3 string
+		)
+
+		it.Before(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "remote-edit-layers")
+			h.AssertNil(t, err)
+			img, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.WithHistory())
+			h.AssertNil(t, err)
+
+			var layerPath1, layerPath2, layerPath3 string
+			layerPath1, diffID1, _ = h.RandomLayer(t, tmpDir)
+			layerPath2, diffID2, _ = h.RandomLayer(t, tmpDir)
+			layerPath3, diffID3, _ = h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(layerPath1, diffID1, v1.History{CreatedBy: "layer-1"}))
+			h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(layerPath2, diffID2, v1.History{CreatedBy: "layer-2"}))
+			h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(layerPath3, diffID3, v1.History{CreatedBy: "layer-3"}))
+		})
+
+		it.After(func() {
+			os.RemoveAll(tmpDir)
+		})
+
+		it("describes the layers from the bottom layer to the top layer", func() {
+			descriptors, err := img.LayerDescriptors()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(descriptors), 3)
+			h.AssertEq(t, descriptors[0].DiffID, diffID1)
+			h.AssertEq(t, descriptors[1].DiffID, diffID2)
+			h.AssertEq(t, descriptors[2].DiffID, diffID3)
+			for _, descriptor := range descriptors {
+				h.AssertNotEq(t, descriptor.Digest, descriptor.DiffID)
+				h.AssertNotEq(t, descriptor.Size, int64(0))
+			}
+		})
+
+		it("removes and replaces layers along with their history", func() {
+			h.AssertNil(t, img.RemoveLayer(diffID2))
+			newLayerPath, newDiffID, _ := h.RandomLayer(t, tmpDir)
+			h.AssertNil(t, img.ReplaceLayer(diffID1, newLayerPath))
+
+			h.AssertNil(t, img.Save())
+
+			h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{newDiffID, diffID3})
+			savedImg, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			history, err := savedImg.History()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(history), 2)
+			h.AssertEq(t, history[0].CreatedBy, "layer-1")
+			h.AssertEq(t, history[1].CreatedBy, "layer-3")
+		})
+
+		it("errors when the layer does not exist", func() {
+			newLayerPath, _, _ := h.RandomLayer(t, tmpDir)
+			h.AssertError(t, img.ReplaceLayer("sha256:some-missing-layer", newLayerPath), `image does not contain layer with diff ID "sha256:some-missing-layer"`)
+		})
+	})
+
 	when("#ReuseLayer", func() {
 		when("previous image", func() {
 			var (
@@ -1527,6 +1992,39 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			when("the WithHistory option is used", func() {
+				it("keeps the history of the base image with normalized times", func() {
+					img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithHistory())
+					h.AssertNil(t, err)
+					tarPath, err := h.CreateSingleFileLayerTar("/base-layer.txt", "base-layer", "linux")
+					h.AssertNil(t, err)
+					defer os.Remove(tarPath)
+					h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(tarPath, h.FileDiffID(t, tarPath), v1.History{CreatedBy: "base-layer"}))
+					history, err := img.History()
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.SetHistory(append(history, v1.History{CreatedBy: "ENV", EmptyLayer: true})))
+					h.AssertNil(t, img.Save())
+
+					img, err = remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName), remote.WithHistory())
+					h.AssertNil(t, err)
+					appLayerPath, err := h.CreateSingleFileLayerTar("/app-layer.txt", "app-layer", "linux")
+					h.AssertNil(t, err)
+					defer os.Remove(appLayerPath)
+					h.AssertNil(t, img.AddLayerWithDiffIDAndHistory(appLayerPath, h.FileDiffID(t, appLayerPath), v1.History{CreatedBy: "app-layer"}))
+					h.AssertNil(t, img.Save())
+
+					configFile := h.FetchManifestImageConfigFile(t, repoName)
+					h.AssertEq(t, len(configFile.History), 3)
+					h.AssertEq(t, configFile.History[0].CreatedBy, "base-layer")
+					h.AssertEq(t, configFile.History[1].CreatedBy, "ENV")
+					h.AssertEq(t, configFile.History[1].EmptyLayer, true)
+					h.AssertEq(t, configFile.History[2].CreatedBy, "app-layer")
+					for _, item := range configFile.History {
+						h.AssertEq(t, item.Created.Unix(), imgutil.NormalizedDateTime.Unix())
+					}
+				})
+			})
+
 			when("the WithCreatedAt option is used", func() {
 				it("uses the value for all times and client specific fields", func() {
 					expectedTime := time.Date(2022, 1, 5, 5, 5, 5, 0, time.UTC)
@@ -35,10 +35,14 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 	if err != nil {
 		return errors.Wrap(err, "get image layers")
 	}
-	cfg.History = make([]v1.History, len(layers))
-	for j := range cfg.History {
-		cfg.History[j] = v1.History{
-			Created: v1.Time{Time: i.createdAt},
+	if i.withHistory {
+		cfg.History = imgutil.NormalizedHistory(imgutil.LayersHistory(cfg.History, len(layers)), i.createdAt)
+	} else {
+		cfg.History = make([]v1.History, len(layers))
+		for j := range cfg.History {
+			cfg.History[j] = v1.History{
+				Created: v1.Time{Time: i.createdAt},
+			}
 		}
 	}
 
@@ -49,6 +53,8 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 		return errors.Wrap(err, "zeroing history")
 	}
 
+	i.image = imgutil.WithManifestAnnotations(i.image, i.annotations)
+
 	if len(layers) == 0 && i.addEmptyLayerOnSave {
 		empty := static.NewLayer([]byte{}, types.OCILayer)
 		i.image, err = mutate.AppendLayers(i.image, empty)
@@ -71,10 +77,37 @@ func (i *Image) SaveAs(name string, additionalNames ...string) error {
 }
 
 func (i *Image) doSave(imageName string) error {
-	reg := getRegistry(i.repoName, i.registrySettings)
+	reg := getRegistry(i.repoName, i.registrySettings, i.logger)
 	ref, auth, err := referenceForRepoName(i.keychain, imageName, reg.insecure)
 	if err != nil {
 		return err
 	}
-	return remote.Write(ref, i.image, remote.WithAuth(auth))
+	if i.progress == nil {
+		return remote.Write(ref, i.image, remote.WithAuth(auth), remote.WithContext(i.ctx))
+	}
+
+	// updates are not closed when the write fails before starting, so the reader is also stopped once the write returns
+	updates := make(chan v1.Update)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					return
+				}
+				if update.Error == nil {
+					i.progress(imgutil.Progress{Complete: update.Complete, Total: update.Total})
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err = remote.Write(ref, i.image, remote.WithAuth(auth), remote.WithContext(i.ctx), remote.WithProgress(updates))
+	close(stop)
+	<-done
+	return err
 }
@@ -0,0 +1,264 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// Squash merges the layer with the provided diff ID and all the layers above it into a single layer, applying the
+// OCI whiteout semantics: entries overwritten or deleted by a higher layer are dropped, and whiteouts are only kept
+// when they apply to the layers below the squashed ones. The layers are read with GetLayer, so any Image can be squashed.
+// Entries keep their headers and are written from the bottom layer to the top layer, so that squashing the same layers
+// always produces the same layer. The squashed layer is recorded with a history entry combining the entries of the
+// squashed layers.
+// Squash returns the path of the squashed layer, or an empty path when there is a single layer to squash. As the image
+// reads the layer when it is saved, the caller removes the file once the image is saved.
+func Squash(image Image, fromDiffID string) (string, error) {
+	descriptors, err := image.LayerDescriptors()
+	if err != nil {
+		return "", errors.Wrapf(err, "getting layers of image %q", image.Name())
+	}
+	fromIdx := -1
+	for idx, descriptor := range descriptors {
+		if descriptor.DiffID == fromDiffID {
+			fromIdx = idx
+			break
+		}
+	}
+	if fromIdx < 0 {
+		return "", fmt.Errorf("image %q does not contain layer with diff ID %q", image.Name(), fromDiffID)
+	}
+	diffIDs := make([]string, 0, len(descriptors)-fromIdx)
+	for _, descriptor := range descriptors[fromIdx:] {
+		diffIDs = append(diffIDs, descriptor.DiffID)
+	}
+	for _, descriptor := range descriptors[:fromIdx] {
+		for _, diffID := range diffIDs {
+			if descriptor.DiffID == diffID {
+				// layers are removed by diff ID, which would remove the layer below the squashed ones
+				return "", fmt.Errorf("cannot squash layer %q as it is also below layer %q", diffID, fromDiffID)
+			}
+		}
+	}
+	if len(diffIDs) == 1 {
+		return "", nil
+	}
+
+	// everything that can fail is done before the layers are removed, so that the image is never left half squashed
+	history, err := image.History()
+	if err != nil {
+		return "", errors.Wrapf(err, "getting history of image %q", image.Name())
+	}
+	squashedHistory := squashHistory(LayersHistory(layersOnly(history), len(descriptors))[fromIdx:])
+
+	f, err := ioutil.TempFile("", "imgutil.squash.*.tar")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temp file")
+	}
+	layerPath := f.Name()
+	if err := f.Close(); err != nil {
+		os.Remove(layerPath)
+		return "", err
+	}
+	diffID, err := squashLayers(image, diffIDs, fromIdx > 0, layerPath)
+	if err != nil {
+		os.Remove(layerPath)
+		return "", errors.Wrapf(err, "squashing layers of image %q", image.Name())
+	}
+
+	// RemoveLayer removes the lowest layer with the diff ID, which is in the squashed layers as none is below them,
+	// so it is called once for each squashed layer, including a layer added again on top
+	for _, diffID := range diffIDs {
+		if err := image.RemoveLayer(diffID); err != nil {
+			return "", err
+		}
+	}
+	return layerPath, image.AddLayerWithDiffIDAndHistory(layerPath, diffID, squashedHistory)
+}
+
+// layersOnly returns the entries of the provided history that are not empty layers.
+func layersOnly(history []v1.History) []v1.History {
+	var entries []v1.History
+	for _, entry := range history {
+		if !entry.EmptyLayer {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// squashHistory returns a history entry combining the provided entries, from the bottom layer to the top layer:
+// the commands and comments are joined, and the author and creation time are the ones of the top layer.
+func squashHistory(history []v1.History) v1.History {
+	var createdBy, comments []string
+	for _, entry := range history {
+		if entry.CreatedBy != "" {
+			createdBy = append(createdBy, entry.CreatedBy)
+		}
+		if entry.Comment != "" {
+			comments = append(comments, entry.Comment)
+		}
+	}
+	top := history[len(history)-1]
+	return v1.History{
+		Author:    top.Author,
+		Created:   top.Created,
+		CreatedBy: strings.Join(createdBy, " && "),
+		Comment:   strings.Join(comments, "; "),
+	}
+}
+
+// squashLayers writes the provided layers of the image, from the bottom layer to the top layer, as a single layer
+// at the provided path and returns its diff ID. Whiteouts are kept if the image has layers below the provided layers.
+func squashLayers(image Image, diffIDs []string, hasLowerLayers bool, layerPath string) (string, error) {
+	// find the entries to keep from the top layer to the bottom layer, as higher layers hide the entries of lower layers
+	keep := make([][]bool, len(diffIDs))
+	hidden := newHiddenPaths()
+	for idx := len(diffIDs) - 1; idx >= 0; idx-- {
+		// the entries of a layer only hide the entries of lower layers
+		layerHidden := newHiddenPaths()
+		err := readLayer(image, diffIDs[idx], func(hdr *tar.Header, _ io.Reader) error {
+			name := entryPath(hdr.Name)
+			dir, base := path.Split(name)
+			dir = path.Clean(dir)
+			if hidden.hides(name) {
+				keep[idx] = append(keep[idx], false)
+				return nil
+			}
+			switch {
+			case base == opaqueWhiteout:
+				layerHidden.opaque[dir] = true
+			case strings.HasPrefix(base, whiteoutPrefix):
+				layerHidden.deleted[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+			default:
+				layerHidden.overwritten[name] = true
+				keep[idx] = append(keep[idx], true)
+				return nil
+			}
+			// keep a single whiteout for the layers below the squashed ones
+			keep[idx] = append(keep[idx], hasLowerLayers)
+			layerHidden.overwritten[name] = true
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		hidden.add(layerHidden)
+	}
+
+	// write the entries to keep from the bottom layer to the top layer
+	f, err := os.Create(filepath.Clean(layerPath))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(f, hasher))
+	for idx, diffID := range diffIDs {
+		entry := 0
+		err := readLayer(image, diffID, func(hdr *tar.Header, r io.Reader) error {
+			defer func() { entry++ }()
+			if entry >= len(keep[idx]) || !keep[idx][entry] {
+				return nil
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// readLayer calls fn for each entry of the layer of the image with the provided diff ID.
+func readLayer(image Image, diffID string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	rc, err := image.GetLayer(diffID)
+	if err != nil {
+		return errors.Wrapf(err, "getting layer %q", diffID)
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading layer %q", diffID)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return errors.Wrapf(err, "squashing layer %q", diffID)
+		}
+	}
+}
+
+// entryPath returns the path of a layer entry, without the leading "./" or "/" and the trailing "/" of directories.
+func entryPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// hiddenPaths holds the paths hidden from lower layers by the entries of higher layers.
+type hiddenPaths struct {
+	// overwritten holds the paths of the entries, deleted the paths of whiteouts and their descendants,
+	// and opaque the directories of opaque whiteouts whose descendants are hidden.
+	overwritten map[string]bool
+	deleted     map[string]bool
+	opaque      map[string]bool
+}
+
+func newHiddenPaths() *hiddenPaths {
+	return &hiddenPaths{overwritten: map[string]bool{}, deleted: map[string]bool{}, opaque: map[string]bool{}}
+}
+
+// hides returns true if the entry with the provided path is overwritten or deleted by a higher layer.
+func (h *hiddenPaths) hides(name string) bool {
+	if h.overwritten[name] || h.deleted[name] {
+		return true
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if h.deleted[dir] || h.opaque[dir] {
+			return true
+		}
+		if dir == "." {
+			return false
+		}
+	}
+}
+
+func (h *hiddenPaths) add(other *hiddenPaths) {
+	for name := range other.overwritten {
+		h.overwritten[name] = true
+	}
+	for name := range other.deleted {
+		h.deleted[name] = true
+	}
+	for name := range other.opaque {
+		h.opaque[name] = true
+	}
+}
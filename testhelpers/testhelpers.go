@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -369,6 +370,24 @@ func FetchManifestImageConfigFile(t *testing.T, repoName string) *v1.ConfigFile
 	return configFile
 }
 
+func FetchManifest(t *testing.T, repoName string) *v1.Manifest {
+	t.Helper()
+
+	r, err := name.ParseReference(repoName, name.WeakValidation)
+	AssertNil(t, err)
+
+	auth, err := authn.DefaultKeychain.Resolve(r.Context().Registry)
+	AssertNil(t, err)
+
+	gImg, err := remote.Image(r, remote.WithTransport(http.DefaultTransport), remote.WithAuth(auth))
+	AssertNil(t, err)
+
+	manifest, err := gImg.Manifest()
+	AssertNil(t, err)
+
+	return manifest
+}
+
 func FileDiffID(t *testing.T, path string) string {
 	tarFile, err := os.Open(filepath.Clean(path))
 	AssertNil(t, err)
@@ -463,6 +482,51 @@ func RandomLayer(t *testing.T, tmpDir string) (path string, sha string, contents
 	return path, "sha256:" + sha, contentsBuf.Bytes()
 }
 
+// FilesLayer creates a layer in tmpDir holding a file for each of the provided paths, with the provided contents,
+// written in the order of their paths. It returns the path and the diff ID of the layer.
+func FilesLayer(t *testing.T, tmpDir string, files map[string]string) (path string, sha string) {
+	t.Helper()
+
+	paths := make([]string, 0, len(files))
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	path = filepath.Join(tmpDir, RandString(10)+".tar")
+	fh, err := os.Create(path)
+	AssertNil(t, err)
+	defer fh.Close()
+
+	tw := tar.NewWriter(fh)
+	for _, filePath := range paths {
+		AssertNil(t, tw.WriteHeader(&tar.Header{Name: filePath, Size: int64(len(files[filePath])), Mode: 0644}))
+		_, err := tw.Write([]byte(files[filePath]))
+		AssertNil(t, err)
+	}
+	AssertNil(t, tw.Close())
+
+	return path, FileDiffID(t, path)
+}
+
+// LayerFiles returns the contents of the files of the provided uncompressed layer by path.
+func LayerFiles(t *testing.T, layer io.Reader) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		AssertNil(t, err)
+		contents, err := ioutil.ReadAll(tr)
+		AssertNil(t, err)
+		files[hdr.Name] = string(contents)
+	}
+}
+
 func RemoteRunnableBaseImage(t *testing.T) v1.Image {
 	testImageName := "busybox"
 	var opts []remote.Option
@@ -600,3 +664,30 @@ func ReadManifestAndConfigFile(t *testing.T, path string) (*v1.Manifest, *v1.Con
 	configFile := ReadConfigFile(t, manifest, path)
 	return manifest, configFile
 }
+
+// Logger records the messages logged to it, as an imgutil.Logger
+type Logger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.record(msg)
+}
+
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.record(msg)
+}
+
+func (l *Logger) record(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+// Messages returns the messages logged so far
+func (l *Logger) Messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.messages...)
+}
@@ -0,0 +1,82 @@
+package imgutil
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/pkg/errors"
+)
+
+// underlyingImage is implemented by images that are backed by a v1.Image, such as remote images.
+type underlyingImage interface {
+	UnderlyingImage() v1.Image
+}
+
+// underlyingImageBuilder is implemented by images that build a v1.Image on demand, such as local images.
+type underlyingImageBuilder interface {
+	UnderlyingImage() (v1.Image, error)
+}
+
+// V1Image returns a v1.Image exposing the config and layers of the provided Image,
+// so that an Image from one backend can be used with an Image from another backend, e.g. as the new base when rebasing.
+func V1Image(image Image) (v1.Image, error) {
+	switch img := image.(type) {
+	case v1.Image:
+		return img, nil
+	case underlyingImage:
+		return img.UnderlyingImage(), nil
+	case underlyingImageBuilder:
+		return img.UnderlyingImage()
+	default:
+		return nil, fmt.Errorf("image %q does not expose its config and layers", image.Name())
+	}
+}
+
+// RebaseV1Image replaces the layers of the image up to and including the layer with the provided diff ID with the layers
+// of the new base, and adopts the OS, architecture, OS version and variant of the new base.
+func RebaseV1Image(image v1.Image, baseTopLayer string, newBase v1.Image) (v1.Image, error) {
+	rebased, err := mutate.Rebase(image, &baseImage{Image: image, topDiffID: baseTopLayer}, newBase)
+	if err != nil {
+		return nil, errors.Wrap(err, "rebase")
+	}
+
+	configFile, err := rebased.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	newBaseConfigFile, err := newBase.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	configFile = configFile.DeepCopy()
+	configFile.Architecture = newBaseConfigFile.Architecture
+	configFile.OS = newBaseConfigFile.OS
+	configFile.OSVersion = newBaseConfigFile.OSVersion
+	configFile.Variant = newBaseConfigFile.Variant
+	return mutate.ConfigFile(rebased, configFile)
+}
+
+// baseImage is the old base of an image being rebased: the layers of the image up to and including the top layer
+// of the base. mutate.Rebase only reads the layers and the config file of the old base.
+type baseImage struct {
+	v1.Image
+	topDiffID string
+}
+
+func (b *baseImage) Layers() ([]v1.Layer, error) {
+	all, err := b.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range all {
+		d, err := l.DiffID()
+		if err != nil {
+			return nil, err
+		}
+		if d.String() == b.topDiffID {
+			return all[0 : i+1], nil
+		}
+	}
+	return nil, errors.New("could not find base layer in image")
+}